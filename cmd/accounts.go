@@ -4,19 +4,46 @@ import (
 	"container/list"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/stellar/go/keypair"
 
 	"boscoin.io/sebak/lib/block"
 	"boscoin.io/sebak/lib/common"
+	"boscoin.io/sebak/lib/errors"
 	"boscoin.io/sebak/lib/network"
+	"boscoin.io/sebak/lib/transaction"
+	"boscoin.io/sebak/lib/transaction/operation"
 )
 
 type Account struct {
 	KP      *keypair.Full
 	Balance common.Amount
+
+	// sequenceID is the sequence id of the next transaction; it is valid only
+	// when sequenced. resequence is set when the local sequence id may be
+	// wrong; it is fetched again after the transactions in flight are
+	// finished.
+	sequenceID uint64
+	sequenced  bool
+	resequence bool
+	// inflight is the number of transactions, which are sent by the source
+	// and not yet finished; pending is the amount of them.
+	inflight int
+	pending  common.Amount
+}
+
+// setBalance sets the balance from node except the pending amount, which is
+// not yet confirmed. am.Lock() must be held.
+func (a *Account) setBalance(balance common.Amount) {
+	if balance < a.pending {
+		a.Balance = 0
+		return
+	}
+	a.Balance = balance - a.pending
 }
 
 type AccountManager struct {
@@ -28,13 +55,140 @@ type AccountManager struct {
 	accounts  map[string]*Account
 	created   map[string]bool
 	unused    *list.List
+	journal   *Journal
+	quota     *QuotaStore
+	rebalance RebalanceOptions
+
+	masterLock sync.Mutex
+
+	// maxInflight is the maximum number of transactions of one source in
+	// flight.
+	maxInflight int
+
+	// retiring is the removed sources, which are still sending transaction
+	retiring      map[string]*Account
+	reloadLock    sync.Mutex
+	sourcesLoader SourcesLoader
+
+	events   *eventBus
+	webhooks *Webhooks
+
+	// pending is the unfinished create-account requests by address; the
+	// same request for the address is attached to it.
+	pending map[string]ReadyAccount
+	// claims is the pending requests, which are not yet journaled, by id.
+	claims map[string]*requestClaim
+	// payments is the amount of the unfinished payments by address.
+	payments map[string]common.Amount
+
+	// unaffordable is the number of dispatches of the requests, which no
+	// source can afford, by id.
+	unaffordable map[string]int
+
+	// expired is the sent transactions, which are not confirmed in time, by
+	// hash; they are reconciled with node by watchJournal.
+	expired map[string]*expiredTransaction
+	// journalRetention is how long the finished requests are kept in
+	// journal; 0 keeps them forever.
+	journalRetention time.Duration
+
+	paused   bool
+	closed   bool
+	running  int
+	disabled map[string]bool
+	inflight map[string]InflightTransaction
+	stopped  chan struct{}
+	done     chan struct{}
+	// workers is the goroutines, which may write the journal; Stop waits
+	// them before the journal is closed.
+	workers sync.WaitGroup
+
+	createChan chan []ReadyAccount
+	flushChan  chan struct{}
+	pool       *list.List // []ReadyAccount
+}
+
+// RequestOptions is the optional parameters of request.
+type RequestOptions struct {
+	CallbackURL string
+	// Reserve reserves the quota of the new request and returns the reserved
+	// keys; it is not called for the attached request.
+	Reserve func(address string, amount common.Amount) ([]string, error)
+
+	// MaxBalance limits the payment; the current Balance of account, the
+	// unfinished payments to it and the new payment can not be over
+	// MaxBalance. 0 is unlimited.
+	Balance    common.Amount
+	MaxBalance common.Amount
+}
+
+// InflightTransaction is the transaction which is sent by source and not yet
+// confirmed.
+type InflightTransaction struct {
+	Hash       string        `json:"hash"`
+	Source     string        `json:"source"`
+	SequenceID uint64        `json:"sequence_id"`
+	Operations int           `json:"operations"`
+	Amount     common.Amount `json:"amount"`
+	Sent       time.Time     `json:"sent"`
+}
+
+// expiredTransaction is the sent transaction, which is not confirmed in
+// time; it may still be confirmed, so it's requests are not sent again.
+type expiredTransaction struct {
+	Hash       string
+	Source     string
+	SequenceID uint64
+	Pool       []ReadyAccount
+	// Expired is when the transaction is expired; it is reconciled until
+	// expiredDeadline after it.
+	Expired time.Time
+}
+
+// SourceStatus is the snapshot of source account.
+type SourceStatus struct {
+	Address  string        `json:"address"`
+	Balance  common.Amount `json:"balance"`
+	Busy     bool          `json:"busy"`
+	Inflight int           `json:"inflight"`
+	Disabled bool          `json:"disabled"`
+}
+
+// RequestConflictError is returned when the address has the pending
+// create-account request with different balance.
+type RequestConflictError struct {
+	Address string
+	Pending ReadyAccount
+}
+
+func (e *RequestConflictError) Error() string {
+	return fmt.Sprintf(
+		"account is already requested with different balance; address=%s id=%s balance=%s",
+		e.Address, e.Pending.ID, e.Pending.Balance,
+	)
+}
 
-	checkCreateChan chan ReadyAccount
-	createChan      chan []ReadyAccount
-	pool            *list.List // []ReadyAccount
+// maxUnaffordableAttempts is the number of dispatches before the request,
+// which no source can afford, fails.
+const maxUnaffordableAttempts int = 20
+
+// ReserveError is returned when the quota of the request can not be
+// reserved.
+type ReserveError struct {
+	Err error
+}
+
+func (e *ReserveError) Error() string {
+	return e.Err.Error()
 }
 
-func NewAccountManager(networkID []byte, kp *keypair.Full, endpoint *common.Endpoint, accounts map[string]*Account) *AccountManager {
+var (
+	errUnaffordable = fmt.Errorf("no source can afford the request")
+	errIntakePaused = fmt.Errorf("angelbot is paused; try again later")
+	errShuttingDown = fmt.Errorf("angelbot is shutting down; try again later")
+)
+
+func NewAccountManager(networkID []byte, kp *keypair.Full, endpoint *common.Endpoint, accounts map[string]*Account, journal *Journal) *AccountManager {
 	http2Client, _ := common.NewHTTP2Client(
 		60*time.Second,
 		60*time.Second,
@@ -43,16 +197,59 @@ func NewAccountManager(networkID []byte, kp *keypair.Full, endpoint *common.Endp
 	client := network.NewHTTP2NetworkClient(endpoint, http2Client)
 
 	return &AccountManager{
-		networkID:       networkID,
-		kp:              kp,
-		client:          client,
-		accounts:        accounts,
-		created:         map[string]bool{},
-		checkCreateChan: make(chan ReadyAccount, 100),
-		createChan:      make(chan []ReadyAccount, 100),
-		pool:            list.New(),
-		unused:          list.New(),
+		networkID:    networkID,
+		kp:           kp,
+		client:       client,
+		accounts:     accounts,
+		created:      map[string]bool{},
+		createChan:   make(chan []ReadyAccount, 100),
+		flushChan:    make(chan struct{}, 1),
+		disabled:     map[string]bool{},
+		inflight:     map[string]InflightTransaction{},
+		retiring:     map[string]*Account{},
+		pending:      map[string]ReadyAccount{},
+		claims:       map[string]*requestClaim{},
+		payments:     map[string]common.Amount{},
+		expired:      map[string]*expiredTransaction{},
+		unaffordable: map[string]int{},
+		events:       newEventBus(),
+		stopped:      make(chan struct{}),
+		done:         make(chan struct{}),
+		pool:         list.New(),
+		unused:       list.New(),
+		journal:      journal,
+		rebalance:    DefaultRebalanceOptions,
+		maxInflight:  1,
+	}
+}
+
+func (am *AccountManager) SetRebalanceOptions(options RebalanceOptions) {
+	am.rebalance = options
+}
+
+// SetMaxInflight sets the maximum number of transactions of one source in
+// flight; the node must accept the next sequence id before the previous
+// transaction is confirmed.
+func (am *AccountManager) SetMaxInflight(n int) {
+	if n < 1 {
+		n = 1
 	}
+	am.maxInflight = n
+}
+
+// SetJournalRetention sets how long the finished requests are kept in
+// journal; 0 keeps them forever.
+func (am *AccountManager) SetJournalRetention(retention time.Duration) {
+	am.journalRetention = retention
+}
+
+// SetQuota sets the QuotaStore to release the quota of the failed requests.
+func (am *AccountManager) SetQuota(quota *QuotaStore) {
+	am.quota = quota
+}
+
+func (am *AccountManager) SetWebhooks(webhooks *Webhooks) {
+	am.webhooks = webhooks
 }
 
 func (am *AccountManager) Start() {
@@ -64,10 +261,289 @@ func (am *AccountManager) Start() {
 
 	log.Debug("unused", "len", am.unused.Len())
 
+	// the balances of newly created sources
+	for address, created := range am.created {
+		if !created {
+			am.refreshBalance(am.accounts[address])
+		}
+	}
+
+	am.replayJournal()
+
+	am.workers.Add(1)
 	go am.watchCheckCreateAccount()
+
+	am.workers.Add(1)
+	go am.watchJournal()
+
+	if am.rebalance.Interval > 0 {
+		am.workers.Add(1)
+		go am.watchRebalance()
+	}
+}
+
+// replayJournal pushes back the queued requests of journal to the pool. The
+// batched, submitted and expired requests may be already sent, so they are
+// kept expired and reconciled with node.
+func (am *AccountManager) replayJournal() {
+	entries, err := am.journal.Unfinished()
+	if err != nil {
+		log.Error("failed to load journal", "error", err)
+		return
+	}
+
+	// expiring is the requests, which were in flight before restart, by
+	// hash.
+	expiring := map[string][]ReadyAccount{}
+	for _, entry := range entries {
+		ra := entry.ReadyAccount()
+		if ra.OperationType() == operation.TypeCreateAccount {
+			if p, found := am.pending[ra.Address]; found {
+				am.updateRequests([]ReadyAccount{ra}, RequestFailed, "", "", fmt.Errorf("duplicated request; id=%s", p.ID))
+				continue
+			}
+			am.pending[ra.Address] = ra
+		} else {
+			am.payments[ra.Address] += ra.Balance
+		}
+
+		switch entry.State {
+		case RequestBatched, RequestSubmitted, RequestExpired:
+			etx, found := am.expired[entry.Hash]
+			if !found {
+				etx = &expiredTransaction{Hash: entry.Hash, Source: entry.Source, SequenceID: entry.SequenceID, Expired: time.Now()}
+				am.expired[entry.Hash] = etx
+			}
+			etx.Pool = append(etx.Pool, ra)
+
+			// the expired request keeps the time of expiry, so the restart
+			// does not extend the deadline.
+			if entry.State != RequestExpired {
+				expiring[entry.Hash] = append(expiring[entry.Hash], ra)
+			} else if entry.Updated.Before(etx.Expired) {
+				etx.Expired = entry.Updated
+			}
+			continue
+		}
+
+		am.pool.PushBack(ra)
+	}
+
+	for hash, pool := range expiring {
+		etx := am.expired[hash]
+		am.updateRequests(pool, RequestExpired, etx.Hash, etx.Source, fmt.Errorf("transaction is not finished before restart"))
+	}
+	am.reconcileExpired()
+
+	log.Debug("journal replayed", "requests", len(entries), "pool", am.pool.Len(), "expired", len(am.expired))
+}
+
+// watchJournal reconciles the expired transactions and prunes the finished
+// requests of journal periodically.
+func (am *AccountManager) watchJournal() {
+	defer am.workers.Done()
+
+	reconcile := time.NewTicker(reconcileInterval)
+	defer reconcile.Stop()
+	prune := time.NewTicker(journalPruneInterval)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-am.stopped:
+			return
+		case <-reconcile.C:
+			am.reconcileExpired()
+		case <-prune.C:
+			am.pruneJournal()
+		}
+	}
+}
+
+// expireRequests keeps the requests of the expired transaction, until it's
+// outcome is known by reconcileExpired.
+func (am *AccountManager) expireRequests(pool []ReadyAccount, hash, source string, sequenceID uint64, err error) {
+	am.Lock()
+	am.expired[hash] = &expiredTransaction{Hash: hash, Source: source, SequenceID: sequenceID, Pool: pool, Expired: time.Now()}
+	am.Unlock()
+
+	am.updateRequests(pool, RequestExpired, hash, source, err)
+}
+
+// reconcileExpired checks the expired transactions with node; the requests
+// of the confirmed transaction are confirmed and the requests of the dropped
+// transaction are queued again. The transaction, which node does not have
+// after expiredDeadline, fails with it's requests and their quota is
+// released; it's sequence id may never be used by the next transaction of
+// the retired or idle source.
+func (am *AccountManager) reconcileExpired() {
+	am.RLock()
+	var etxs []*expiredTransaction
+	for _, etx := range am.expired {
+		etxs = append(etxs, etx)
+	}
+	am.RUnlock()
+
+	for _, etx := range etxs {
+		outcome, err := am.checkTransaction(etx.Hash, etx.Source, etx.SequenceID)
+		if len(outcome) < 1 {
+			if err != nil {
+				// node tells nothing, so the deadline is not applied.
+				log.Debug("failed to reconcile expired transaction", "transaction", etx.Hash, "error", err)
+				continue
+			} else if time.Since(etx.Expired) < expiredDeadline {
+				continue
+			}
+
+			// the transaction in the pool of node is still waited.
+			if status, _ := am.transactionStatus(etx.Hash); status != "notfound" {
+				continue
+			}
+		}
+
+		// the transaction is reconciled by the other call.
+		am.Lock()
+		if _, found := am.expired[etx.Hash]; !found {
+			am.Unlock()
+			continue
+		}
+		delete(am.expired, etx.Hash)
+		am.dropRetiring(etx.Source)
+		am.Unlock()
+
+		if len(outcome) < 1 {
+			err = fmt.Errorf("transaction is not stored in %s after expired; transaction=%s", expiredDeadline, etx.Hash)
+			log.Error("expired transaction is given up", "transaction", etx.Hash, "requests", len(etx.Pool), "error", err)
+			am.updateRequests(etx.Pool, RequestFailed, etx.Hash, etx.Source, err)
+			continue
+		}
+
+		if outcome == TransactionConfirmed {
+			log.Info("expired transaction is confirmed", "transaction", etx.Hash, "requests", len(etx.Pool))
+			am.updateRequests(etx.Pool, RequestConfirmed, etx.Hash, etx.Source, nil)
+			continue
+		}
+
+		log.Info("expired transaction is dropped", "transaction", etx.Hash, "requests", len(etx.Pool), "error", err)
+		am.retryRequests(etx.Pool, err)
+	}
+}
+
+// pruneJournal deletes the finished requests older than journalRetention.
+func (am *AccountManager) pruneJournal() {
+	if am.journalRetention < 1 {
+		return
+	}
+
+	pruned, err := am.journal.Prune(time.Now().Add(-am.journalRetention))
+	if err != nil {
+		log.Error("failed to prune journal", "error", err)
+		return
+	}
+	if pruned > 0 {
+		log.Debug("journal pruned", "requests", pruned)
+	}
+}
+
+func (am *AccountManager) updateRequests(pool []ReadyAccount, state RequestState, hash, source string, err error) {
+	if state.Finished() {
+		am.Lock()
+		for _, ra := range pool {
+			if p, found := am.pending[ra.Address]; found && p.ID == ra.ID {
+				delete(am.pending, ra.Address)
+			}
+			if ra.OperationType() == operation.TypePayment {
+				am.releasePayment(ra)
+			}
+			delete(am.unaffordable, ra.ID)
+		}
+		am.Unlock()
+	}
+
+	// the sequence id of the batched transaction is kept, so it's outcome can
+	// be checked after restart.
+	var sequenceID uint64
+	if state == RequestBatched {
+		am.RLock()
+		sequenceID = am.inflight[hash].SequenceID
+		am.RUnlock()
+	}
+
+	for _, ra := range pool {
+		var release bool
+		entry, uerr := am.journal.Update(ra.ID, func(entry *RequestEntry) {
+			if state == RequestConfirmed && entry.State != RequestConfirmed {
+				metricDisbursed.Add(float64(entry.Balance))
+				metricConfirmationLatency.Observe(time.Since(entry.Created).Seconds())
+			}
+			release = state == RequestFailed && entry.State != RequestFailed
+
+			entry.State = state
+			entry.Hash = hash
+			entry.Source = source
+			if state == RequestBatched || state == RequestQueued {
+				entry.SequenceID = sequenceID
+			}
+			entry.Error = ""
+			if err != nil {
+				entry.Error = err.Error()
+			}
+		})
+		if uerr != nil {
+			log.Error("failed to update journal", "id", ra.ID, "state", state, "error", uerr)
+			continue
+		}
+
+		if release {
+			am.releaseQuota(entry.ReadyAccount())
+		}
+
+		if am.webhooks != nil && state.Finished() {
+			am.webhooks.Notify(*entry)
+		}
+	}
+
+	// the queued events are published by pushPool with the position
+	if state != RequestQueued {
+		am.publishRequests(pool, state, hash, source, err)
+	}
+}
+
+// releaseQuota gives back the quota reserved for the requests, which are
+// not funded.
+func (am *AccountManager) releaseQuota(ras ...ReadyAccount) {
+	if am.quota == nil {
+		return
+	}
+
+	for _, ra := range ras {
+		am.quota.ReleaseKeys(ra.Reserved, ra.Balance)
+	}
+}
+
+// accountNotFound returns true if the error of getAccount means the account
+// does not exist; the other errors, like network error, tell nothing about
+// the account.
+func accountNotFound(err error) bool {
+	e, ok := err.(*errors.Error)
+	if !ok {
+		return false
+	}
+	if e.Code == errors.BlockAccountDoesNotExists.Code {
+		return true
+	}
+
+	status, ok := e.Data["status"].(int)
+	return ok && status == 404
 }
 
-func (am *AccountManager) checkCreatedAccount(id int, account *Account) *Account {
+type sourceCheck struct {
+	account *Account
+	missing bool
+	err     error
+}
+
+func (am *AccountManager) checkCreatedAccount(id int, account *Account) sourceCheck {
 	log.Debug("trying to check account created", "acconnt", account)
 
 	defer func() {
@@ -77,26 +553,34 @@ func (am *AccountManager) checkCreatedAccount(id int, account *Account) *Account
 	ba, err := getAccount(am.client, account.KP.Address())
 	if err != nil {
 		log.Debug("found error during checking account", "error", err)
-		return account
+		return sourceCheck{account: account, missing: accountNotFound(err), err: err}
 	}
 
 	// fill balance
 	account.Balance = ba.Balance
+	metricSourceBalance.WithLabelValues(account.KP.Address()).Set(float64(ba.Balance))
 
-	return nil
+	return sourceCheck{account: account}
 }
 
-func (am *AccountManager) checkCreatedAccounts(id int, accountsChan <-chan *Account, errChan chan<- *Account) {
+func (am *AccountManager) checkCreatedAccounts(id int, accountsChan <-chan *Account, errChan chan<- sourceCheck) {
 	for account := range accountsChan {
 		errChan <- am.checkCreatedAccount(id, account)
 	}
 }
 
-func (am *AccountManager) startCheckCreatedAccounts() {
-	log.Debug("startCheckCreatedAccounts")
+// checkSources checks the source accounts concurrently and returns the
+// accounts, which do not exist. The accounts, which can not be checked by the
+// other errors, are returned in unchecked by address; they must not be
+// created again.
+func (am *AccountManager) checkSources(accounts map[string]*Account) (nonAccount []*Account, unchecked map[string]error) {
+	unchecked = map[string]error{}
+	if len(accounts) < 1 {
+		return
+	}
 
 	accountsChan := make(chan *Account)
-	errChan := make(chan *Account)
+	errChan := make(chan sourceCheck)
 	defer close(errChan)
 
 	numWorker := 50
@@ -106,32 +590,46 @@ func (am *AccountManager) startCheckCreatedAccounts() {
 	}
 
 	go func() {
-		for _, account := range am.accounts {
+		for _, account := range accounts {
 			accountsChan <- account
 		}
 		close(accountsChan)
 	}()
 
 	var returned int
-	var nonAccount []*Account
 
 errorCheck:
 	for {
 		select {
-		case account := <-errChan:
+		case checked := <-errChan:
 			returned++
-			if account != nil {
-				nonAccount = append(nonAccount, account)
+			if checked.missing {
+				nonAccount = append(nonAccount, checked.account)
+			} else if checked.err != nil {
+				unchecked[checked.account.KP.Address()] = checked.err
 			}
-			if returned == len(am.accounts) {
+			if returned == len(accounts) {
 				break errorCheck
 			}
 		}
 	}
 
+	return
+}
+
+func (am *AccountManager) startCheckCreatedAccounts() {
+	log.Debug("startCheckCreatedAccounts")
+
+	nonAccount, unchecked := am.checkSources(am.accounts)
+
 	for _, account := range nonAccount {
 		am.created[account.KP.Address()] = false
 	}
+	// the unchecked sources are used as they are; if they do not exist, the
+	// transactions of them are rejected.
+	for address, err := range unchecked {
+		log.Error("failed to check source; it is not created", "address", address, "error", err)
+	}
 	for address, _ := range am.accounts {
 		if _, ok := am.created[address]; ok {
 			continue
@@ -139,7 +637,7 @@ errorCheck:
 		am.created[address] = true
 	}
 
-	log.Debug("checking done", "none-exists", len(nonAccount), "accounts", len(am.accounts))
+	log.Debug("checking done", "none-exists", len(nonAccount), "unchecked", len(unchecked), "accounts", len(am.accounts))
 
 	am.startCreateAccounts(nonAccount)
 }
@@ -147,231 +645,1191 @@ errorCheck:
 func (am *AccountManager) startCreateAccounts(accounts []*Account) {
 	log.Debug("startCreateAccounts")
 
-	limit := 300
-
-	for i := 0; i < int(len(accounts)/limit)+1; i++ {
-		s := i * limit
-		if s > len(accounts) {
-			break
+	for _, batch := range am.createSources(accounts, am.rebalance.HighBalance, time.Second*60) {
+		if batch.err != nil {
+			log.Error("failed to create sources", "accounts", len(batch.accounts), "error", batch.err)
 		}
-		e := s + limit
+	}
+
+	log.Debug("created done")
+}
+
+type sourcesBatch struct {
+	accounts []*Account
+	hash     string
+	err      error
+}
+
+// createSources creates the accounts from master account by the batches of
+// maxOperationsInTransaction; the failed batch does not stop the next batches.
+func (am *AccountManager) createSources(accounts []*Account, amount common.Amount, timeout time.Duration) (batches []sourcesBatch) {
+	for s := 0; s < len(accounts); s += maxOperationsInTransaction {
+		e := s + maxOperationsInTransaction
 		if e > len(accounts) {
 			e = len(accounts)
 		}
-		if s == e {
-			break
-		}
-		fmt.Println(">>", i, s, e, len(accounts), len(accounts[s:e]))
 
 		var ras []ReadyAccount
 		for _, account := range accounts[s:e] {
 			ras = append(
 				ras,
 				ReadyAccount{
+					Type:    operation.TypeCreateAccount,
 					Address: account.KP.Address(),
-					Balance: common.Amount(1000000000000),
+					Balance: amount,
 				},
 			)
 		}
 
-		var sequenceID uint64
-		if body, err := am.client.Get("/api/v1/accounts/" + am.kp.Address()); err != nil {
-			log.Error("failed to get seed account", "error", err)
-		} else {
-			var ba block.BlockAccount
-			if err = json.Unmarshal(body, &ba); err != nil {
-				log.Error("failed to get seed account", "error", err)
-				return
-			}
+		hash, err := am.sendFromMaster(ras, timeout)
+		batches = append(batches, sourcesBatch{accounts: accounts[s:e], hash: hash, err: err})
+	}
 
-			sequenceID = ba.SequenceID
-		}
-		tx, err := createAccountTransaction(am.networkID, am.kp, sequenceID, time.Second*60, ras...)
-		if err != nil {
-			log.Error("failed to make transaction", "error", err)
-			return
-		}
+	return
+}
 
-		log.Debug("sent transaction", "transaction", tx.GetHash())
-		_, err = am.client.SendTransaction(tx)
-		if err != nil {
-			log.Error("failed to send transaction", "error", err)
-			return
-		}
+// sendFromMaster sends the operations from the master account and waits until
+// the transaction is confirmed.
+func (am *AccountManager) sendFromMaster(ras []ReadyAccount, timeout time.Duration) (hash string, err error) {
+	am.masterLock.Lock()
+	defer am.masterLock.Unlock()
 
-		// check
+	var sequenceID uint64
+	if sequenceID, err = am.getSequenceID(am.kp.Address()); err != nil {
+		log.Error("failed to get master account", "error", err)
+		return
+	}
 
-	endChecking:
-		for {
-			select {
-			case <-time.After(time.Second * 60):
-				log.Error("failed to confirmed", "transaction", tx.GetHash())
-				break endChecking
-			default:
-				if _, err := am.client.Get("/api/v1/transactions/" + tx.GetHash()); err != nil {
-					time.Sleep(time.Second * 5)
-					continue
-				}
-				break endChecking
-			}
-		}
+	tx, err := newBatchTransaction(am.networkID, am.kp, sequenceID, timeout, ras...)
+	if err != nil {
+		log.Error("failed to make transaction", "error", err)
+		return
+	}
+	hash = tx.GetHash()
 
-		log.Debug("confirmed", "transaction", tx.GetHash())
+	log.Debug("sent transaction", "transaction", hash)
+	if err = am.sendTransaction(tx); err != nil {
+		log.Error("failed to send transaction", "error", err)
+		return
 	}
 
-	log.Debug("created done")
-}
+	if err = am.confirmTransaction(hash, am.kp.Address(), sequenceID, timeout); err != nil {
+		log.Error("failed to confirmed", "transaction", hash)
+		return
+	}
 
-type ReadyAccount struct {
-	Address string
-	Balance common.Amount
+	log.Debug("confirmed", "transaction", hash)
+
+	return
 }
 
-func (am *AccountManager) CreateAccount(address string, balance common.Amount) {
-	am.checkCreateChan <- ReadyAccount{Address: address, Balance: balance}
+func (am *AccountManager) getSequenceID(address string) (sequenceID uint64, err error) {
+	defer observeUpstream("get-account", time.Now())
+
+	var body []byte
+	if body, err = am.client.Get("/api/v1/accounts/" + address); err != nil {
+		return
+	}
+
+	var ba block.BlockAccount
+	if err = json.Unmarshal(body, &ba); err != nil {
+		return
+	}
+
+	sequenceID = ba.SequenceID
+	return
 }
 
-func (am *AccountManager) watchCheckCreateAccount() {
-	limit := 300
+func (am *AccountManager) sendTransaction(tx transaction.Transaction) (err error) {
+	defer observeUpstream("send-transaction", time.Now())
 
-	go func() {
-		ticker := time.NewTicker(time.Second * 3)
-		for _ = range ticker.C {
-			var pool []ReadyAccount
+	_, err = am.client.SendTransaction(tx)
+	return
+}
 
-			var l int
-			if am.pool.Len() < 1 {
-				continue
-			}
+// TransactionOutcome is the final result of the sent transaction.
+type TransactionOutcome string
+
+const (
+	TransactionConfirmed TransactionOutcome = "confirmed"
+	// TransactionRejected is not stored in block and will never be; it is
+	// rejected by node or it's sequence id is already used by the other
+	// transaction, so the operations can be sent again.
+	TransactionRejected TransactionOutcome = "rejected"
+	// TransactionExpired is not confirmed in timeout; it may still be
+	// confirmed later.
+	TransactionExpired TransactionOutcome = "expired"
+)
 
-			if am.pool.Len() > limit {
-				l = limit
-			} else {
-				l = am.pool.Len()
-			}
+const (
+	reconcileInterval time.Duration = 30 * time.Second
+	// expiredDeadline is how long the expired transaction is reconciled,
+	// while node answers that it is not found.
+	expiredDeadline      time.Duration = 10 * time.Minute
+	journalPruneInterval time.Duration = time.Hour
+)
 
-			var es []*list.Element
-			for e := am.pool.Front(); e != nil; e = e.Next() {
-				if len(pool) == l {
-					break
-				}
-				pool = append(pool, e.Value.(ReadyAccount))
-				es = append(es, e)
-			}
+const (
+	transactionTimeout    time.Duration = 60 * time.Second
+	maxTransactionRetries int           = 3
+)
 
-			for _, e := range es {
-				am.pool.Remove(e)
-			}
+// TransactionRejectedError is the reason of rejected transaction; the
+// operations of it can be retried.
+type TransactionRejectedError struct {
+	Hash   string
+	Reason error
+	// SequenceMismatch is set when node rejected the sequence id; it is not
+	// the fault of the requests.
+	SequenceMismatch bool
+}
 
-			if len(pool) < 1 {
-				continue
-			}
-			go func(pool []ReadyAccount) {
-				err := am.createAccounts(pool)
-				if err == nil {
-					return
-				}
-				for _, ra := range pool {
-					am.pool.PushBack(ra)
-				}
-			}(pool)
-		}
-	}()
+func (e *TransactionRejectedError) Error() string {
+	return fmt.Sprintf("transaction rejected; transaction=%s: %v", e.Hash, e.Reason)
+}
 
-	for {
-		select {
-		case ra := <-am.checkCreateChan:
-			am.pool.PushBack(ra)
+// nodeRejection returns the error of node, if the transaction is explicitly
+// rejected by node with the client error. The other errors, like network
+// errors, do not tell whether node received the transaction.
+func nodeRejection(err error) (*errors.Error, bool) {
+	e, ok := err.(*errors.Error)
+	if !ok || e.Code < 1 {
+		return nil, false
+	}
+
+	if status, found := e.Data["status"]; found {
+		code, ok := status.(int)
+		if !ok || code < 400 || code >= 500 {
+			return nil, false
 		}
 	}
+
+	return e, true
 }
 
-func (am *AccountManager) checkCreateAccounts(pool []ReadyAccount) {
-	if len(pool) < 300 {
-		return
-	}
+// getTransaction returns nil if the transaction is stored in block.
+func (am *AccountManager) getTransaction(hash string) (err error) {
+	defer observeUpstream("get-transaction", time.Now())
+
+	_, err = am.client.Get("/api/v1/transactions/" + hash)
+	return
 }
 
-func (am *AccountManager) createAccounts(pool []ReadyAccount) error {
-	source := am.nextSource()
-	if source == nil {
-		return fmt.Errorf("nextSource() == nil")
+// transactionStatus returns the status of transaction from node, one of
+// `confirmed`, `submitted` and `notfound`.
+func (am *AccountManager) transactionStatus(hash string) (status string, err error) {
+	defer observeUpstream("get-transaction-status", time.Now())
+
+	var body []byte
+	if body, err = am.client.Get("/api/v1/transactions/" + hash + "/status"); err != nil {
+		return
 	}
 
-	defer func() {
-		am.Lock()
-		am.unused.PushBack(source.KP.Address())
-		am.Unlock()
-		log.Debug("unused back", "unused", am.unused.Len(), "accounts", len(am.accounts))
-	}()
+	var c struct {
+		Status string `json:"status"`
+	}
+	if err = json.Unmarshal(body, &c); err != nil {
+		return
+	}
+	status = c.Status
 
-	log.Debug("nextSource", "source", source.KP.Address(), "pool", len(pool))
+	return
+}
 
-	var addresses []string
-	for _, a := range pool {
-		addresses = append(addresses, a.Address)
+// checkTransaction checks the outcome of the sent transaction once; the
+// empty outcome is not decided yet. The transaction is rejected only when it
+// is not found and it's sequence id is already used, so it can never be
+// stored.
+func (am *AccountManager) checkTransaction(hash, source string, sequenceID uint64) (TransactionOutcome, error) {
+	// the sequence id is fetched before the status; if the transaction was
+	// stored before the sequence id is used, the status is confirmed.
+	current, serr := am.getSequenceID(source)
+
+	status, err := am.transactionStatus(hash)
+	switch {
+	case err != nil:
+		if am.getTransaction(hash) == nil {
+			return TransactionConfirmed, nil
+		}
+		return "", err
+	case status == "confirmed":
+		return TransactionConfirmed, nil
+	case status == "notfound" && serr == nil && current > sequenceID:
+		return TransactionRejected, &TransactionRejectedError{
+			Hash:   hash,
+			Reason: fmt.Errorf("not stored and sequence id is already used; sequence-id=%d current=%d", sequenceID, current),
+		}
 	}
 
-	var sequenceID uint64
-	if body, err := am.client.Get("/api/v1/accounts/" + source.KP.Address()); err != nil {
-		log.Error("failed to get seed account", "error", err)
-		return err
-	} else {
-		var ba block.BlockAccount
-		if err = json.Unmarshal(body, &ba); err != nil {
-			log.Error("failed to get seed account", "error", err)
-			return err
+	return "", nil
+}
+
+// waitTransaction waits until the outcome of the sent transaction is
+// decided or timeout.
+func (am *AccountManager) waitTransaction(hash, source string, sequenceID uint64, timeout time.Duration) (TransactionOutcome, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		outcome, err := am.checkTransaction(hash, source, sequenceID)
+		if len(outcome) > 0 {
+			return outcome, err
+		} else if err != nil {
+			log.Debug("failed to get transaction status", "transaction", hash, "error", err)
+		}
+
+		select {
+		case <-timer.C:
+			return TransactionExpired, fmt.Errorf("transaction is not confirmed in %s; transaction=%s", timeout, hash)
+		case <-am.stopped:
+			// it is reconciled after restart.
+			return TransactionExpired, fmt.Errorf("stopped before transaction is confirmed; transaction=%s", hash)
+		case <-time.After(time.Second * 5):
 		}
+	}
+}
+
+// confirmTransaction waits until the transaction is stored in block.
+func (am *AccountManager) confirmTransaction(hash, source string, sequenceID uint64, timeout time.Duration) error {
+	_, err := am.waitTransaction(hash, source, sequenceID, timeout)
+	return err
+}
+
+// Request returns the journaled state of the request.
+func (am *AccountManager) Request(id string) (*RequestEntry, error) {
+	return am.journal.Get(id)
+}
+
+// ReadyAccount is the request to be included in the transaction; by it's
+// Type, the account is created or paid.
+type ReadyAccount struct {
+	ID      string                  `json:"id"`
+	Type    operation.OperationType `json:"type"`
+	Address string                  `json:"address"`
+	Balance common.Amount           `json:"balance"`
+
+	// Reserved is the quota keys reserved for the request.
+	Reserved []string `json:"-"`
+}
+
+func (ra ReadyAccount) OperationType() operation.OperationType {
+	if len(ra.Type) < 1 {
+		return operation.TypeCreateAccount
+	}
+
+	return ra.Type
+}
+
+func (am *AccountManager) CreateAccount(address string, balance common.Amount, options RequestOptions) (ReadyAccount, error) {
+	return am.request(operation.TypeCreateAccount, address, balance, options)
+}
+
+// Pay sends the amount to the existing account.
+func (am *AccountManager) Pay(address string, amount common.Amount, options RequestOptions) (ReadyAccount, error) {
+	return am.request(operation.TypePayment, address, amount, options)
+}
+
+func (am *AccountManager) request(opType operation.OperationType, address string, balance common.Amount, options RequestOptions) (ra ReadyAccount, err error) {
+	var queued, added []ReadyAccount
+	var errs []error
+	if queued, added, errs, err = am.journalRequests(opType, []ReadyAccount{{Address: address, Balance: balance}}, options); err != nil {
+		return
+	} else if errs[0] != nil {
+		return ra, errs[0]
+	}
+	ra = queued[0]
+
+	am.pushPool(added...)
 
-		sequenceID = ba.SequenceID
+	return
+}
+
+// CreateAccounts queues the accounts together, so they are sent in as few
+// transactions as possible. The rejected account has the error in errs.
+func (am *AccountManager) CreateAccounts(ras []ReadyAccount, options RequestOptions) (queued []ReadyAccount, errs []error, err error) {
+	var added []ReadyAccount
+	if queued, added, errs, err = am.journalRequests(operation.TypeCreateAccount, ras, options); err != nil {
+		return
 	}
 
-	tx, err := createAccountTransaction(am.networkID, source.KP, sequenceID, time.Second*60, pool...)
+	am.pushPool(added...)
+
+	return
+}
+
+// requestClaim is the new request, which is pending, but not yet journaled;
+// the same requests wait it before they are attached.
+type requestClaim struct {
+	done chan struct{}
+	// err is set if the request is rolled back.
+	err error
+}
+
+// journalRequests assigns the id to the requests and writes them to the
+// journal as queued; it returns the requests in the same order and the newly
+// added ones. The create-account request for the address, which already has
+// the pending one, is attached to it, or it gets RequestConflictError in errs
+// if the balance is different; the attached request does not reserve the
+// quota again. The new requests are claimed as pending under the lock, and
+// then their quota is reserved by options.Reserve and they are journaled
+// without the lock; the claim, which fails to reserve, is rolled back and
+// the requests attached to it are requested again.
+func (am *AccountManager) journalRequests(opType operation.OperationType, ras []ReadyAccount, options RequestOptions) (queued, added []ReadyAccount, errs []error, err error) {
+	am.Lock()
+	if am.closed {
+		err = errShuttingDown
+	} else if am.paused {
+		err = errIntakePaused
+	} else if opType == operation.TypePayment && options.MaxBalance > 0 {
+		err = am.checkPayments(ras, options)
+	}
 	if err != nil {
-		log.Error("failed to make transaction", "error", err)
-		return err
+		am.Unlock()
+		return
 	}
 
-	log.Debug("sent transaction", "transaction", tx.GetHash())
-	_, err = am.client.SendTransaction(tx)
+	queued = make([]ReadyAccount, len(ras))
+	errs = make([]error, len(ras))
+
+	var claimed []ReadyAccount
+	var claimedIndex []int
+	// attaching is the pending requests to be attached by index; waiting is
+	// the claims of them, which are not yet journaled.
+	attaching := map[int]ReadyAccount{}
+	waiting := map[int]*requestClaim{}
+	// the requests claimed by this call are not journaled yet, but they have
+	// the same callback url, so nothing is attached to them.
+	addedAddresses := map[string]bool{}
+	for i, ra := range ras {
+		if opType == operation.TypeCreateAccount {
+			if p, found := am.pending[ra.Address]; found {
+				if p.Balance != ra.Balance {
+					errs[i] = &RequestConflictError{Address: ra.Address, Pending: p}
+					continue
+				}
+				attaching[i] = p
+				if claim, found := am.claims[p.ID]; found {
+					waiting[i] = claim
+				}
+				continue
+			}
+		}
+
+		ra = ReadyAccount{ID: uuid.New().String(), Type: opType, Address: ra.Address, Balance: ra.Balance}
+		if opType == operation.TypeCreateAccount {
+			am.pending[ra.Address] = ra
+			am.claims[ra.ID] = &requestClaim{done: make(chan struct{})}
+		} else {
+			am.payments[ra.Address] += ra.Balance
+		}
+		claimed = append(claimed, ra)
+		claimedIndex = append(claimedIndex, i)
+		addedAddresses[ra.Address] = true
+	}
+	am.Unlock()
+
+	for n, ra := range claimed {
+		i := claimedIndex[n]
+		if options.Reserve != nil {
+			var reserved []string
+			if reserved, errs[i] = options.Reserve(ra.Address, ra.Balance); errs[i] != nil {
+				errs[i] = &ReserveError{Err: errs[i]}
+				am.unclaimRequests(errs[i], ra)
+				continue
+			}
+			ra.Reserved = reserved
+		}
+
+		queued[i] = ra
+		added = append(added, ra)
+	}
+
+	now := time.Now()
+	for i, ra := range added {
+		entry := &RequestEntry{
+			ID:      ra.ID,
+			Type:    opType,
+			Address: ra.Address,
+			Balance: ra.Balance,
+			State:   RequestQueued,
+			Created: now,
+			Updated: now,
+
+			CallbackURL: options.CallbackURL,
+			Reserved:    ra.Reserved,
+		}
+		if err = am.journal.Put(entry); err != nil {
+			log.Error("failed to write journal", "address", ra.Address, "error", err)
+			// the journaled requests are released by updateRequests.
+			am.releaseQuota(added[i:]...)
+			am.unclaimRequests(err, added[i:]...)
+			am.claimRequests(added[:i]...)
+			am.updateRequests(added[:i], RequestFailed, "", "", err)
+			return nil, nil, nil, err
+		}
+	}
+	am.claimRequests(added...)
+
+	for i, p := range attaching {
+		if claim, found := waiting[i]; found {
+			if <-claim.done; claim.err != nil {
+				// the pending request is rolled back, so it is requested
+				// again as new one.
+				var retried, retriedAdded []ReadyAccount
+				var retriedErrs []error
+				if retried, retriedAdded, retriedErrs, errs[i] = am.journalRequests(opType, ras[i:i+1], options); errs[i] == nil {
+					queued[i], errs[i] = retried[0], retriedErrs[0]
+					added = append(added, retriedAdded...)
+				}
+				continue
+			}
+		}
+
+		if !addedAddresses[p.Address] {
+			errs[i] = am.attachRequest(p, options)
+		}
+		if errs[i] == nil {
+			queued[i] = p
+		}
+	}
+
+	return
+}
+
+// claimRequests finishes the claims of the journaled requests, so the
+// requests waiting them are attached.
+func (am *AccountManager) claimRequests(ras ...ReadyAccount) {
+	am.Lock()
+	defer am.Unlock()
+
+	for _, ra := range ras {
+		claim, found := am.claims[ra.ID]
+		if !found {
+			continue
+		}
+		delete(am.claims, ra.ID)
+
+		if p, found := am.pending[ra.Address]; found && p.ID == ra.ID {
+			am.pending[ra.Address] = ra
+		}
+		close(claim.done)
+	}
+}
+
+// unclaimRequests rolls back the claimed requests, which are not journaled.
+func (am *AccountManager) unclaimRequests(err error, ras ...ReadyAccount) {
+	am.Lock()
+	defer am.Unlock()
+
+	for _, ra := range ras {
+		if ra.OperationType() == operation.TypePayment {
+			am.releasePayment(ra)
+			continue
+		}
+
+		if p, found := am.pending[ra.Address]; found && p.ID == ra.ID {
+			delete(am.pending, ra.Address)
+		}
+		if claim, found := am.claims[ra.ID]; found {
+			delete(am.claims, ra.ID)
+			claim.err = err
+			close(claim.done)
+		}
+	}
+}
+
+// attachRequest keeps the callback url of the attached request in the
+// pending one, so it is also notified. If the pending request is finished
+// before it is attached, the webhook of it is sent to the callback url.
+func (am *AccountManager) attachRequest(p ReadyAccount, options RequestOptions) error {
+	if len(options.CallbackURL) < 1 {
+		return nil
+	}
+
+	var finished, attached bool
+	entry, err := am.journal.Update(p.ID, func(entry *RequestEntry) {
+		attached = entry.CallbackURL == options.CallbackURL
+		for _, u := range entry.AttachedCallbackURLs {
+			attached = attached || u == options.CallbackURL
+		}
+		if finished = entry.State.Finished(); finished || attached {
+			return
+		}
+		entry.AttachedCallbackURLs = append(entry.AttachedCallbackURLs, options.CallbackURL)
+	})
 	if err != nil {
-		log.Error("failed to send transaction", "error", err)
 		return err
 	}
 
-endChecking:
+	if finished && !attached && am.webhooks != nil {
+		notified := *entry
+		notified.CallbackURL = options.CallbackURL
+		notified.AttachedCallbackURLs = nil
+		am.webhooks.Notify(notified)
+	}
+
+	return nil
+}
+
+// checkPayments checks the payments with the unfinished payments to the same
+// address are not over options.MaxBalance. am.Lock() must be held.
+func (am *AccountManager) checkPayments(ras []ReadyAccount, options RequestOptions) error {
+	amounts := map[string]common.Amount{}
+	for _, ra := range ras {
+		amounts[ra.Address] += ra.Balance
+	}
+
+	for address, amount := range amounts {
+		pending := am.payments[address]
+		if options.Balance > options.MaxBalance || pending > options.MaxBalance-options.Balance || amount > options.MaxBalance-options.Balance-pending {
+			return errors.OperationAmountOverflow
+		}
+	}
+
+	return nil
+}
+
+// releasePayment removes the finished payment from am.payments. am.Lock()
+// must be held.
+func (am *AccountManager) releasePayment(ra ReadyAccount) {
+	if am.payments[ra.Address] <= ra.Balance {
+		delete(am.payments, ra.Address)
+		return
+	}
+	am.payments[ra.Address] -= ra.Balance
+}
+
+// watchCheckCreateAccount dispatches the pool periodically; it is a worker,
+// so the transactions are not started after Stop waits the workers.
+func (am *AccountManager) watchCheckCreateAccount() {
+	defer am.workers.Done()
+
+	ticker := time.NewTicker(time.Second * 3)
+	defer ticker.Stop()
+
 	for {
 		select {
-		case <-time.After(time.Second * 60):
-			err = fmt.Errorf("failed to confirm")
-			log.Error("failed to confirmed", "transaction", tx.GetHash())
-			break endChecking
-		default:
-			if _, err := am.client.Get("/api/v1/transactions/" + tx.GetHash()); err != nil {
-				time.Sleep(time.Second * 5)
-				continue
+		case <-am.stopped:
+			return
+		case <-ticker.C:
+			am.batchPool()
+		case <-am.flushChan:
+			am.flushPool()
+		}
+	}
+}
+
+// flushPool dispatches the whole pool. The ReadyAccounts, which can not be
+// afforded by sources, are pushed back to the pool, so the number of batches
+// is limited by the current pool.
+func (am *AccountManager) flushPool() {
+	am.RLock()
+	batches := am.pool.Len()/maxOperationsInTransaction + 1
+	am.RUnlock()
+
+	for i := 0; i < batches && am.batchPool(); i++ {
+	}
+}
+
+// batchPool takes the ReadyAccounts from the front of pool and dispatches
+// them; it returns false if the pool is empty.
+func (am *AccountManager) batchPool() bool {
+	limit := maxOperationsInTransaction
+
+	am.Lock()
+	var pool []ReadyAccount
+	var es []*list.Element
+	for e := am.pool.Front(); e != nil; e = e.Next() {
+		if len(pool) == limit {
+			break
+		}
+		pool = append(pool, e.Value.(ReadyAccount))
+		es = append(es, e)
+	}
+
+	for _, e := range es {
+		am.pool.Remove(e)
+	}
+	am.Unlock()
+
+	if len(pool) < 1 {
+		return false
+	}
+	am.dispatch(pool)
+
+	return true
+}
+
+func (am *AccountManager) pushPool(ras ...ReadyAccount) {
+	am.Lock()
+	var events []RequestEvent
+	now := time.Now()
+	for _, ra := range ras {
+		am.pool.PushBack(ra)
+		events = append(events, RequestEvent{
+			ID:       ra.ID,
+			Address:  ra.Address,
+			State:    RequestQueued,
+			Time:     now,
+			Position: am.pool.Len(),
+		})
+	}
+	am.Unlock()
+
+	for _, event := range events {
+		am.events.Publish(event)
+	}
+}
+
+// requeuePool puts back the ReadyAccounts to the front of pool in the same
+// order, so they are dispatched before the newer ones.
+func (am *AccountManager) requeuePool(ras ...ReadyAccount) {
+	am.Lock()
+	for i := len(ras) - 1; i >= 0; i-- {
+		am.pool.PushFront(ras[i])
+	}
+
+	var events []RequestEvent
+	now := time.Now()
+	for i, ra := range ras {
+		events = append(events, RequestEvent{
+			ID:       ra.ID,
+			Address:  ra.Address,
+			State:    RequestQueued,
+			Time:     now,
+			Position: i + 1,
+		})
+	}
+	am.Unlock()
+
+	for _, event := range events {
+		am.events.Publish(event)
+	}
+}
+
+// dispatch sends the pool by the sources which can afford it. If no single
+// source can cover the whole pool, the pool is split across the sources.
+func (am *AccountManager) dispatch(pool []ReadyAccount) {
+	for len(pool) > 0 {
+		source, n := am.nextSource(pool)
+		if source == nil {
+			var busy bool
+			if pool, busy = am.failUnaffordable(pool); len(pool) < 1 {
+				return
+			}
+
+			// while the sources are busy, the pool waits for them.
+			if busy {
+				log.Debug("no source can afford the pool", "pool", len(pool), "amount", batchAmount(pool))
+			} else {
+				log.Error("no source can afford the pool", "pool", len(pool), "amount", batchAmount(pool))
+			}
+			am.requeuePool(pool...)
+			return
+		}
+
+		am.Lock()
+		am.running++
+		am.Unlock()
+
+		am.workers.Add(1)
+		go func(source *Account, pool []ReadyAccount) {
+			defer func() {
+				am.Lock()
+				am.running--
+				am.Unlock()
+				am.workers.Done()
+			}()
+
+			if err := am.createAccounts(source, pool); err != nil {
+				am.retryRequests(pool, err)
+			}
+		}(source, pool[:n])
+
+		pool = pool[n:]
+	}
+}
+
+// retryRequests puts back the requests to the pool. The requests of the
+// rejected transaction fail after maxTransactionRetries.
+func (am *AccountManager) retryRequests(pool []ReadyAccount, err error) {
+	if rejected, ok := err.(*TransactionRejectedError); !ok || rejected.SequenceMismatch {
+		am.updateRequests(pool, RequestQueued, "", "", err)
+		am.requeuePool(pool...)
+		return
+	}
+
+	var retry, failed []ReadyAccount
+	for _, ra := range pool {
+		entry, uerr := am.journal.Update(ra.ID, func(entry *RequestEntry) {
+			entry.Attempts++
+		})
+		if uerr == nil && entry.Attempts > maxTransactionRetries {
+			failed = append(failed, ra)
+			continue
+		}
+		retry = append(retry, ra)
+	}
+
+	if len(failed) > 0 {
+		log.Error("requests failed after retries", "requests", len(failed), "error", err)
+		am.updateRequests(failed, RequestFailed, "", "", err)
+	}
+	if len(retry) > 0 {
+		am.updateRequests(retry, RequestQueued, "", "", err)
+		am.requeuePool(retry...)
+	}
+}
+
+// failUnaffordable fails the ReadyAccounts, which are more than the full
+// balance of every source, after maxUnaffordableAttempts; the others are
+// returned. busy is true if any source has the transactions in flight.
+func (am *AccountManager) failUnaffordable(pool []ReadyAccount) (rest []ReadyAccount, busy bool) {
+	am.Lock()
+	var highest common.Amount
+	for address, account := range am.accounts {
+		if account.inflight > 0 {
+			busy = true
+		}
+		if am.disabled[address] {
+			continue
+		}
+		if balance := account.Balance + account.pending; balance > highest {
+			highest = balance
+		}
+	}
+
+	var failed []ReadyAccount
+	for _, ra := range pool {
+		if coverablePool(highest, []ReadyAccount{ra}) > 0 {
+			delete(am.unaffordable, ra.ID)
+			rest = append(rest, ra)
+			continue
+		}
+
+		am.unaffordable[ra.ID]++
+		if am.unaffordable[ra.ID] < maxUnaffordableAttempts {
+			rest = append(rest, ra)
+			continue
+		}
+		failed = append(failed, ra)
+	}
+	am.Unlock()
+
+	if len(failed) > 0 {
+		log.Error("requests failed; no source can afford them", "requests", len(failed), "highest", highest)
+		am.updateRequests(failed, RequestFailed, "", "", errUnaffordable)
+	}
+
+	return
+}
+
+// batchAmount returns the amount which the source needs to send the pool,
+// including fee.
+func batchAmount(pool []ReadyAccount) (amount common.Amount) {
+	for _, ra := range pool {
+		amount += ra.Balance + common.BaseFee
+	}
+
+	return
+}
+
+func (am *AccountManager) checkCreateAccounts(pool []ReadyAccount) {
+	if len(pool) < 300 {
+		return
+	}
+}
+
+func (am *AccountManager) createAccounts(source *Account, pool []ReadyAccount) (err error) {
+	defer func() {
+		am.Lock()
+		source.pending -= batchAmount(pool)
+		am.Unlock()
+
+		am.refreshBalance(source)
+		am.releaseSource(source)
+	}()
+
+	log.Debug("nextSource", "source", source.KP.Address(), "pool", len(pool))
+
+	var addresses []string
+	for _, a := range pool {
+		addresses = append(addresses, a.Address)
+	}
+
+	sequenceID, err := am.nextSequenceID(source)
+	if err != nil {
+		log.Error("failed to get seed account", "error", err)
+		return err
+	}
+
+	tx, err := newBatchTransaction(am.networkID, source.KP, sequenceID, transactionTimeout, pool...)
+	if err != nil {
+		log.Error("failed to make transaction", "error", err)
+		am.releaseSequenceID(source, sequenceID)
+		return err
+	}
+
+	am.Lock()
+	am.inflight[tx.GetHash()] = InflightTransaction{
+		Hash:       tx.GetHash(),
+		Source:     source.KP.Address(),
+		SequenceID: sequenceID,
+		Operations: len(pool),
+		Amount:     batchAmount(pool),
+		Sent:       time.Now(),
+	}
+	am.Unlock()
+
+	am.updateRequests(pool, RequestBatched, tx.GetHash(), source.KP.Address(), nil)
+
+	defer func() {
+		am.Lock()
+		delete(am.inflight, tx.GetHash())
+		am.Unlock()
+	}()
+
+	log.Debug("sent transaction", "transaction", tx.GetHash())
+	if err = am.sendTransaction(tx); err != nil {
+		if e, rejected := nodeRejection(err); rejected {
+			log.Error("transaction rejected", "transaction", tx.GetHash(), "error", err)
+			metricTransactions.WithLabelValues(source.KP.Address(), "rejected").Inc()
+
+			mismatch := e.Code == errors.TransactionInvalidSequenceID.Code
+			if mismatch {
+				am.resequenceSource(source)
+			} else {
+				am.releaseSequenceID(source, sequenceID)
 			}
-			break endChecking
+
+			return &TransactionRejectedError{Hash: tx.GetHash(), Reason: err, SequenceMismatch: mismatch}
+		}
+
+		// node may have received the transaction, so it is not sent again;
+		// the outcome is checked like the submitted one.
+		log.Error("failed to send transaction; checking the outcome", "transaction", tx.GetHash(), "error", err)
+	}
+	metricTransactions.WithLabelValues(source.KP.Address(), "submitted").Inc()
+
+	am.updateRequests(pool, RequestSubmitted, tx.GetHash(), source.KP.Address(), nil)
+
+	outcome, err := am.waitTransaction(tx.GetHash(), source.KP.Address(), sequenceID, transactionTimeout)
+	metricTransactions.WithLabelValues(source.KP.Address(), string(outcome)).Inc()
+
+	switch outcome {
+	case TransactionConfirmed:
+		log.Debug("confirmed", "transaction", tx.GetHash())
+		am.updateRequests(pool, RequestConfirmed, tx.GetHash(), source.KP.Address(), nil)
+		return nil
+	case TransactionRejected:
+		// the sequence id is used by the other transaction, so the local
+		// sequence id may be behind node.
+		log.Error("transaction rejected", "transaction", tx.GetHash(), "error", err)
+		am.resequenceSource(source)
+		return err
+	default:
+		// the expired transaction may be confirmed later, so it is not sent
+		// again.
+		log.Error("transaction expired", "transaction", tx.GetHash(), "error", err)
+		am.resequenceSource(source)
+		am.expireRequests(pool, tx.GetHash(), source.KP.Address(), sequenceID, err)
+		return nil
+	}
+}
+
+// nextSource takes the unused source, which can afford the pool. If no source
+// can afford the whole pool, the source which can cover the most of pool is
+// taken; n is the number of ReadyAccounts the source covers.
+func (am *AccountManager) nextSource(pool []ReadyAccount) (source *Account, n int) {
+	am.Lock()
+	defer am.Unlock()
+
+	var found *list.Element
+	for e := am.unused.Front(); e != nil; e = e.Next() {
+		account := am.accounts[e.Value.(string)]
+		if account == nil || account.resequence || am.disabled[account.KP.Address()] {
+			continue
+		}
+
+		c := coverablePool(account.Balance, pool)
+		if c > n {
+			found = e
+			n = c
+		}
+		if n == len(pool) {
+			break
+		}
+	}
+
+	if found == nil {
+		return nil, 0
+	}
+
+	source = am.accounts[found.Value.(string)]
+	source.inflight++
+	amount := batchAmount(pool[:n])
+	source.pending += amount
+	source.Balance -= amount
+
+	// the source can send the next transaction before this one is finished,
+	// up to maxInflight.
+	if source.inflight < am.maxInflight {
+		am.unused.MoveToBack(found)
+	} else {
+		am.unused.Remove(found)
+	}
+
+	return
+}
+
+// coverablePool returns how many ReadyAccounts from the front of pool can be
+// sent with the balance.
+func coverablePool(balance common.Amount, pool []ReadyAccount) int {
+	var amount common.Amount
+	for i, ra := range pool {
+		amount += ra.Balance + common.BaseFee
+		if amount > balance {
+			return i
 		}
 	}
 
+	return len(pool)
+}
+
+// nextSequenceID returns the sequence id for the next transaction of the
+// source. It is tracked locally and fetched from node only at first or after
+// resequenceSource.
+func (am *AccountManager) nextSequenceID(source *Account) (uint64, error) {
+	am.Lock()
+	if source.sequenced {
+		sequenceID := source.sequenceID
+		source.sequenceID++
+		am.Unlock()
+
+		return sequenceID, nil
+	}
+	am.Unlock()
+
+	fetched, err := am.getSequenceID(source.KP.Address())
+	if err != nil {
+		return 0, err
+	}
+
+	am.Lock()
+	defer am.Unlock()
+
+	if !source.sequenced {
+		source.sequenceID = fetched
+		source.sequenced = true
+	}
+	sequenceID := source.sequenceID
+	source.sequenceID++
+
+	return sequenceID, nil
+}
+
+// releaseSequenceID gives back the sequence id, which is not used by node.
+// If the next sequence ids are already taken, they will be rejected, so the
+// source is resequenced.
+func (am *AccountManager) releaseSequenceID(source *Account, sequenceID uint64) {
+	am.Lock()
+	defer am.Unlock()
+
+	if source.sequenced && source.sequenceID == sequenceID+1 {
+		source.sequenceID = sequenceID
+		return
+	}
+	source.resequence = true
+}
+
+// resequenceSource marks the local sequence id of the source as unreliable;
+// the source is not used until it's transactions in flight are finished, and
+// then the sequence id is fetched from node by releaseSource.
+func (am *AccountManager) resequenceSource(source *Account) {
+	am.Lock()
+	defer am.Unlock()
+
+	if source.sequenced && !source.resequence {
+		log.Debug("source will be resequenced", "source", source.KP.Address(), "sequence-id", source.sequenceID)
+	}
+	source.resequence = true
+}
+
+// refreshBalance updates the balance of source from the node.
+func (am *AccountManager) refreshBalance(source *Account) {
+	ba, err := getAccount(am.client, source.KP.Address())
 	if err != nil {
+		log.Error("failed to refresh balance of source", "source", source.KP.Address(), "error", err)
+		return
 	}
 
+	am.Lock()
+	source.setBalance(ba.Balance)
+	am.Unlock()
+
+	metricSourceBalance.WithLabelValues(source.KP.Address()).Set(float64(ba.Balance))
+}
+
+func (am *AccountManager) Paused() bool {
+	am.RLock()
+	defer am.RUnlock()
+
+	return am.paused
+}
+
+// Pause stops taking new requests; the requests in pool are still processed.
+func (am *AccountManager) Pause() {
+	am.Lock()
+	defer am.Unlock()
+
+	am.paused = true
+	log.Info("intake paused")
+}
+
+func (am *AccountManager) Resume() {
+	am.Lock()
+	defer am.Unlock()
+
+	am.paused = false
+	log.Info("intake resumed")
+}
+
+// Flush dispatches the all requests in pool immediately.
+func (am *AccountManager) Flush() {
+	select {
+	case am.flushChan <- struct{}{}:
+	default:
+	}
+}
+
+// SetSourceDisabled disables or enables the source; the disabled source is
+// not used for the new transactions.
+func (am *AccountManager) SetSourceDisabled(address string, disabled bool) error {
+	am.Lock()
+	defer am.Unlock()
+
+	if _, found := am.accounts[address]; !found {
+		return fmt.Errorf("unknown source: '%s'", address)
+	}
+
+	if disabled {
+		am.disabled[address] = true
+	} else {
+		delete(am.disabled, address)
+	}
+
+	log.Info("source state changed", "source", address, "disabled", disabled)
+
 	return nil
 }
 
-func (am *AccountManager) nextSource() *Account {
+func (am *AccountManager) Sources() (statuses []SourceStatus) {
+	am.RLock()
+	defer am.RUnlock()
+
+	for address, account := range am.accounts {
+		statuses = append(statuses, SourceStatus{
+			Address:  address,
+			Balance:  account.Balance,
+			Busy:     account.inflight > 0,
+			Inflight: account.inflight,
+			Disabled: am.disabled[address],
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Address < statuses[j].Address
+	})
+
+	return
+}
+
+// Pool returns the ReadyAccounts waiting in pool.
+func (am *AccountManager) Pool() (pool []ReadyAccount) {
+	am.RLock()
+	defer am.RUnlock()
+
+	for e := am.pool.Front(); e != nil; e = e.Next() {
+		pool = append(pool, e.Value.(ReadyAccount))
+	}
+
+	return
+}
+
+func (am *AccountManager) Inflight() (txs []InflightTransaction) {
+	am.RLock()
+	defer am.RUnlock()
+
+	for _, itx := range am.inflight {
+		txs = append(txs, itx)
+	}
+
+	sort.Slice(txs, func(i, j int) bool {
+		return txs[i].Sent.Before(txs[j].Sent)
+	})
+
+	return
+}
+
+// Close stops taking new requests; the requests in pool are processed by
+// Stop.
+func (am *AccountManager) Close() {
 	am.Lock()
 	defer am.Unlock()
 
-	ac := am.unused.Front()
-	if ac == nil {
-		return nil
+	am.closed = true
+}
+
+// Stop stops taking new requests, sends the requests in pool as final batches
+// and waits until the all transactions are finished. The requests, which are
+// not finished until timeout, are kept in journal; Stop returns after the
+// all goroutines writing journal are finished.
+func (am *AccountManager) Stop(timeout time.Duration) (err error) {
+	am.Close()
+
+	log.Info("stopping AccountManager", "pool", len(am.Pool()), "inflight", len(am.Inflight()))
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+end:
+	for {
+		am.flushPool()
+
+		am.RLock()
+		pool, running := am.pool.Len(), am.running
+		am.RUnlock()
+
+		if pool < 1 && running < 1 {
+			break
+		}
+
+		select {
+		case <-deadline.C:
+			err = fmt.Errorf("timeouted; pool=%d running=%d", pool, running)
+			break end
+		case <-ticker.C:
+		}
 	}
-	am.unused.Remove(ac)
 
-	address := ac.Value.(string)
+	close(am.stopped)
+	am.workers.Wait()
+	close(am.done)
+
+	if err != nil {
+		log.Error("AccountManager stopped, but some requests are not finished", "error", err)
+	} else {
+		log.Info("AccountManager stopped")
+	}
+
+	return
+}
 
-	return am.accounts[address]
+// Done is closed when the AccountManager is stopped; after it, the states of
+// requests are not changed until restart.
+func (am *AccountManager) Done() <-chan struct{} {
+	return am.done
 }
@@ -0,0 +1,716 @@
+package cmd
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stellar/go/keypair"
+
+	"boscoin.io/sebak/lib/block"
+	"boscoin.io/sebak/lib/common"
+	"boscoin.io/sebak/lib/network"
+	"boscoin.io/sebak/lib/transaction/operation"
+)
+
+// testNode is the stubbed sebak node; it serves the accounts and the status
+// of transactions, which are set by the test.
+type testNode struct {
+	sync.Mutex
+
+	accounts map[string]*block.BlockAccount
+	// statuses is the status of transaction by hash; the unknown transaction
+	// is notfound.
+	statuses map[string]string
+	// broken is the addresses and the hashes of transaction status, whose
+	// requests are failed by network error.
+	broken map[string]bool
+}
+
+func newTestNode(t *testing.T) (*testNode, *network.HTTP2NetworkClient, func()) {
+	node := &testNode{
+		accounts: map[string]*block.BlockAccount{},
+		statuses: map[string]string{},
+		broken:   map[string]bool{},
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/v1/accounts/{address}", node.accountHandler)
+	router.HandleFunc("/api/v1/transactions/{hash}", node.transactionHandler)
+	router.HandleFunc("/api/v1/transactions/{hash}/status", node.statusHandler)
+	server := httptest.NewServer(router)
+
+	endpoint, err := common.ParseEndpoint(server.URL)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	http2Client, _ := common.NewHTTP2Client(5*time.Second, 5*time.Second, false)
+
+	return node, network.NewHTTP2NetworkClient(endpoint, http2Client), server.Close
+}
+
+func (node *testNode) setAccount(address string, balance common.Amount, sequenceID uint64) {
+	node.Lock()
+	defer node.Unlock()
+
+	node.accounts[address] = &block.BlockAccount{Address: address, Balance: balance, SequenceID: sequenceID}
+}
+
+func (node *testNode) setStatus(hash, status string) {
+	node.Lock()
+	defer node.Unlock()
+
+	node.statuses[hash] = status
+}
+
+func (node *testNode) writeJSON(w http.ResponseWriter, i interface{}) {
+	b, _ := json.Marshal(i)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// hangUp closes the connection without response.
+func (node *testNode) hangUp(w http.ResponseWriter) {
+	if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+		conn.Close()
+	}
+}
+
+func (node *testNode) accountHandler(w http.ResponseWriter, r *http.Request) {
+	node.Lock()
+	defer node.Unlock()
+
+	address := mux.Vars(r)["address"]
+	if node.broken[address] {
+		node.hangUp(w)
+		return
+	}
+
+	ba, found := node.accounts[address]
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	node.writeJSON(w, ba)
+}
+
+func (node *testNode) transactionHandler(w http.ResponseWriter, r *http.Request) {
+	node.Lock()
+	defer node.Unlock()
+
+	if node.statuses[mux.Vars(r)["hash"]] != "confirmed" {
+		http.NotFound(w, r)
+		return
+	}
+	node.writeJSON(w, map[string]string{"hash": mux.Vars(r)["hash"]})
+}
+
+func (node *testNode) statusHandler(w http.ResponseWriter, r *http.Request) {
+	node.Lock()
+	defer node.Unlock()
+
+	if node.broken[mux.Vars(r)["hash"]] {
+		node.hangUp(w)
+		return
+	}
+
+	status, found := node.statuses[mux.Vars(r)["hash"]]
+	if !found {
+		status = "notfound"
+	}
+	node.writeJSON(w, map[string]string{"status": status})
+}
+
+func newTestPool(balances ...common.Amount) (pool []ReadyAccount) {
+	for _, balance := range balances {
+		pool = append(pool, ReadyAccount{Balance: balance})
+	}
+
+	return
+}
+
+func TestCoverablePool(t *testing.T) {
+	a, fee := common.BaseReserve, common.BaseFee
+
+	cases := []struct {
+		name     string
+		balance  common.Amount
+		pool     []ReadyAccount
+		expected int
+	}{
+		{name: "empty pool", balance: a, expected: 0},
+		{name: "all", balance: 3*a + 3*fee, pool: newTestPool(a, a, a), expected: 3},
+		{name: "without fee", balance: 3 * a, pool: newTestPool(a, a, a), expected: 2},
+		{name: "front only", balance: 2*a + 2*fee, pool: newTestPool(a, a, 1), expected: 2},
+		{name: "first too large", balance: 10 * a, pool: newTestPool(10*a, 1), expected: 0},
+		{name: "no balance", balance: 0, pool: newTestPool(1), expected: 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if n := coverablePool(c.balance, c.pool); n != c.expected {
+				t.Errorf("expected %d; got %d", c.expected, n)
+			}
+		})
+	}
+}
+
+type testSource struct {
+	balance    common.Amount
+	resequence bool
+	disabled   bool
+}
+
+func newTestAccountManager(t *testing.T, maxInflight int, sources []testSource) (*AccountManager, []string) {
+	am := &AccountManager{
+		accounts:    map[string]*Account{},
+		created:     map[string]bool{},
+		disabled:    map[string]bool{},
+		retiring:    map[string]*Account{},
+		unused:      list.New(),
+		maxInflight: maxInflight,
+	}
+
+	var addresses []string
+	for _, s := range sources {
+		kp, err := keypair.Random()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		address := kp.Address()
+		am.accounts[address] = &Account{KP: kp, Balance: s.balance, resequence: s.resequence}
+		am.created[address] = true
+		am.disabled[address] = s.disabled
+		am.unused.PushBack(address)
+		addresses = append(addresses, address)
+	}
+
+	return am, addresses
+}
+
+func TestNextSource(t *testing.T) {
+	a, fee := common.BaseReserve, common.BaseFee
+	pool := newTestPool(a, a, a)
+
+	cases := []struct {
+		name        string
+		sources     []testSource
+		maxInflight int
+		// source is the index of the expected source; -1 is none.
+		source int
+		n      int
+	}{
+		{name: "no source", source: -1, maxInflight: 1},
+		{name: "first covering all", sources: []testSource{{balance: 10 * a}, {balance: 10 * a}}, maxInflight: 1, source: 0, n: 3},
+		{name: "covering most", sources: []testSource{{balance: a + fee}, {balance: 2*a + 2*fee}}, maxInflight: 1, source: 1, n: 2},
+		{name: "skip resequence", sources: []testSource{{balance: 10 * a, resequence: true}, {balance: 10 * a}}, maxInflight: 1, source: 1, n: 3},
+		{name: "skip disabled", sources: []testSource{{balance: 10 * a, disabled: true}, {balance: 10 * a}}, maxInflight: 1, source: 1, n: 3},
+		{name: "unaffordable", sources: []testSource{{balance: a}}, maxInflight: 1, source: -1},
+		{name: "keep for inflight", sources: []testSource{{balance: 10 * a}, {balance: 10 * a}}, maxInflight: 2, source: 0, n: 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			am, addresses := newTestAccountManager(t, c.maxInflight, c.sources)
+
+			source, n := am.nextSource(pool)
+			if c.source < 0 {
+				if source != nil || n != 0 {
+					t.Fatalf("expected no source; got %v n=%d", source, n)
+				}
+				if am.unused.Len() != len(c.sources) {
+					t.Errorf("unused is changed; %d", am.unused.Len())
+				}
+				return
+			}
+
+			if source == nil {
+				t.Fatal("expected source")
+			}
+			if source.KP.Address() != addresses[c.source] {
+				t.Errorf("expected source %d", c.source)
+			}
+			if n != c.n {
+				t.Errorf("expected n=%d; got %d", c.n, n)
+			}
+
+			amount := batchAmount(pool[:n])
+			if source.inflight != 1 || source.pending != amount {
+				t.Errorf("expected inflight=1 pending=%d; got inflight=%d pending=%d", amount, source.inflight, source.pending)
+			}
+			if source.Balance != c.sources[c.source].balance-amount {
+				t.Errorf("expected balance=%d; got %d", c.sources[c.source].balance-amount, source.Balance)
+			}
+
+			// the source stays in unused at the back until maxInflight.
+			var found bool
+			for e := am.unused.Front(); e != nil; e = e.Next() {
+				if e.Value.(string) == addresses[c.source] {
+					found = true
+					if e != am.unused.Back() {
+						t.Error("source is not moved to back")
+					}
+				}
+			}
+			if expected := c.maxInflight > 1; found != expected {
+				t.Errorf("expected source in unused=%v; got %v", expected, found)
+			}
+		})
+	}
+}
+
+func TestJournalRequests(t *testing.T) {
+	a := common.BaseReserve
+	pendingCallbackURL := "https://a.example.com/callback"
+	callbackURL := "https://b.example.com/callback"
+	errQuota := errors.New("quota exceeded")
+
+	const (
+		added    = "added"
+		attached = "attached"
+		conflict = "conflict"
+		reserve  = "reserve"
+	)
+
+	cases := []struct {
+		name        string
+		ras         []ReadyAccount
+		callbackURL string
+		reserveErr  error
+		// claim is how the claim of the pending request, which is not yet
+		// journaled, is finished; empty is journaled already.
+		claim string
+		// outcomes is the expected outcome of each request.
+		outcomes []string
+		// attachedURLs is the expected attached callback urls of the pending
+		// request.
+		attachedURLs []string
+	}{
+		{
+			name:     "new address",
+			ras:      []ReadyAccount{{Address: "GB", Balance: a}},
+			outcomes: []string{added},
+		},
+		{
+			name:         "same balance",
+			ras:          []ReadyAccount{{Address: "GA", Balance: a}},
+			callbackURL:  callbackURL,
+			outcomes:     []string{attached},
+			attachedURLs: []string{callbackURL},
+		},
+		{
+			name:        "same callback url",
+			ras:         []ReadyAccount{{Address: "GA", Balance: a}},
+			callbackURL: pendingCallbackURL,
+			outcomes:    []string{attached},
+		},
+		{
+			name:     "different balance",
+			ras:      []ReadyAccount{{Address: "GA", Balance: 2 * a}},
+			outcomes: []string{conflict},
+		},
+		{
+			name:        "duplicated in request",
+			ras:         []ReadyAccount{{Address: "GB", Balance: a}, {Address: "GB", Balance: a}, {Address: "GB", Balance: 2 * a}},
+			callbackURL: callbackURL,
+			outcomes:    []string{added, attached, conflict},
+		},
+		{
+			name:         "claim journaled",
+			ras:          []ReadyAccount{{Address: "GA", Balance: a}},
+			callbackURL:  callbackURL,
+			claim:        "journaled",
+			outcomes:     []string{attached},
+			attachedURLs: []string{callbackURL},
+		},
+		{
+			name:        "claim rolled back",
+			ras:         []ReadyAccount{{Address: "GA", Balance: a}},
+			callbackURL: callbackURL,
+			claim:       "rolled back",
+			outcomes:    []string{added},
+		},
+		{
+			name:       "quota exceeded",
+			ras:        []ReadyAccount{{Address: "GB", Balance: a}, {Address: "GA", Balance: a}},
+			reserveErr: errQuota,
+			outcomes:   []string{reserve, attached},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, closeDB := openTestDB(t)
+			defer closeDB()
+			journal := &Journal{db: db}
+
+			pending := ReadyAccount{ID: "pending", Type: operation.TypeCreateAccount, Address: "GA", Balance: a}
+			now := time.Now()
+			err := journal.Put(&RequestEntry{
+				ID:          pending.ID,
+				Type:        pending.Type,
+				Address:     pending.Address,
+				Balance:     pending.Balance,
+				State:       RequestQueued,
+				CallbackURL: pendingCallbackURL,
+				Created:     now,
+				Updated:     now,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			am := &AccountManager{
+				journal:  journal,
+				pending:  map[string]ReadyAccount{pending.Address: pending},
+				claims:   map[string]*requestClaim{},
+				payments: map[string]common.Amount{},
+			}
+			if len(c.claim) > 0 {
+				am.claims[pending.ID] = &requestClaim{done: make(chan struct{})}
+				go func() {
+					// the request waits until the claim is finished.
+					time.Sleep(50 * time.Millisecond)
+					if c.claim == "journaled" {
+						am.claimRequests(pending)
+					} else {
+						am.unclaimRequests(errQuota, pending)
+					}
+				}()
+			}
+
+			var reserved []string
+			options := RequestOptions{
+				CallbackURL: c.callbackURL,
+				Reserve: func(address string, amount common.Amount) ([]string, error) {
+					// the quota is reserved without the lock.
+					unlocked := make(chan struct{})
+					go func() {
+						am.RLock()
+						am.RUnlock()
+						close(unlocked)
+					}()
+					select {
+					case <-unlocked:
+					case <-time.After(time.Second):
+						t.Error("quota is reserved under the lock")
+					}
+
+					reserved = append(reserved, address)
+					if c.reserveErr != nil {
+						return nil, c.reserveErr
+					}
+					return []string{"address-" + address}, nil
+				},
+			}
+
+			queued, newRequests, errs, err := am.journalRequests(operation.TypeCreateAccount, c.ras, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var expectedReserved []string
+			var expectedAdded int
+			for i, outcome := range c.outcomes {
+				switch outcome {
+				case added:
+					expectedReserved = append(expectedReserved, c.ras[i].Address)
+					expectedAdded++
+					if errs[i] != nil {
+						t.Fatalf("%d: %v", i, errs[i])
+					}
+
+					entry, err := journal.Get(queued[i].ID)
+					if err != nil {
+						t.Fatalf("%d: request is not journaled; %v", i, err)
+					}
+					if entry.State != RequestQueued || entry.CallbackURL != c.callbackURL {
+						t.Errorf("%d: unexpected entry; %v", i, entry)
+					}
+					if !reflect.DeepEqual(entry.Reserved, []string{"address-" + c.ras[i].Address}) {
+						t.Errorf("%d: unexpected reserved; %v", i, entry.Reserved)
+					}
+					if p := am.pending[c.ras[i].Address]; p.ID != queued[i].ID {
+						t.Errorf("%d: expected pending %s; got %s", i, queued[i].ID, p.ID)
+					}
+				case attached:
+					if errs[i] != nil {
+						t.Fatalf("%d: %v", i, errs[i])
+					}
+					if p := am.pending[c.ras[i].Address]; queued[i].ID != p.ID {
+						t.Errorf("%d: expected attached to %s; got %s", i, p.ID, queued[i].ID)
+					}
+				case conflict:
+					if _, ok := errs[i].(*RequestConflictError); !ok {
+						t.Errorf("%d: expected RequestConflictError; got %v", i, errs[i])
+					}
+				case reserve:
+					expectedReserved = append(expectedReserved, c.ras[i].Address)
+					if e, ok := errs[i].(*ReserveError); !ok || e.Err != c.reserveErr {
+						t.Errorf("%d: expected ReserveError; got %v", i, errs[i])
+					}
+					if _, found := am.pending[c.ras[i].Address]; found {
+						t.Errorf("%d: rejected request is pending", i)
+					}
+				}
+			}
+
+			if len(newRequests) != expectedAdded {
+				t.Errorf("expected %d added; got %d", expectedAdded, len(newRequests))
+			}
+			if !reflect.DeepEqual(reserved, expectedReserved) {
+				t.Errorf("expected reserved %v; got %v", expectedReserved, reserved)
+			}
+
+			entry, err := journal.Get(pending.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(entry.AttachedCallbackURLs, c.attachedURLs) {
+				t.Errorf("expected attached callback urls %v; got %v", c.attachedURLs, entry.AttachedCallbackURLs)
+			}
+		})
+	}
+}
+
+func TestCheckTransaction(t *testing.T) {
+	cases := []struct {
+		name string
+		// status is the status of transaction in node; the empty one is
+		// notfound.
+		status string
+		// sequenceID is the current sequence id of source in node; 0 is the
+		// source not in node.
+		sequenceID uint64
+		broken     bool
+		outcome    TransactionOutcome
+		err        bool
+	}{
+		{name: "confirmed", status: "confirmed", sequenceID: 11, outcome: TransactionConfirmed},
+		{name: "submitted", status: "submitted", sequenceID: 10},
+		{name: "notfound", sequenceID: 10},
+		{name: "sequence id used", sequenceID: 11, outcome: TransactionRejected, err: true},
+		{name: "source not found", status: ""},
+		{name: "status failed but stored", status: "confirmed", sequenceID: 11, broken: true, outcome: TransactionConfirmed},
+		{name: "status failed", sequenceID: 11, broken: true, err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			node, client, closeNode := newTestNode(t)
+			defer closeNode()
+
+			if c.sequenceID > 0 {
+				node.setAccount("GS", common.BaseReserve, c.sequenceID)
+			}
+			if len(c.status) > 0 {
+				node.setStatus("tx", c.status)
+			}
+			node.broken["tx"] = c.broken
+
+			am := &AccountManager{client: client}
+			outcome, err := am.checkTransaction("tx", "GS", 10)
+			if outcome != c.outcome {
+				t.Errorf("expected outcome '%s'; got '%s'", c.outcome, outcome)
+			}
+			if c.err && err == nil {
+				t.Error("expected error")
+			} else if !c.err && err != nil {
+				t.Error(err)
+			}
+			if _, ok := err.(*TransactionRejectedError); ok != (c.outcome == TransactionRejected) {
+				t.Errorf("unexpected error; %v", err)
+			}
+		})
+	}
+}
+
+// newTestJournalManager returns the AccountManager with the journaled
+// requests, which reserved the quota.
+func newTestJournalManager(t *testing.T, state RequestState, attempts int, n int) (*AccountManager, []ReadyAccount, func()) {
+	db, closeDB := openTestDB(t)
+
+	am := &AccountManager{
+		journal:      &Journal{db: db},
+		quota:        &QuotaStore{db: db},
+		pending:      map[string]ReadyAccount{},
+		payments:     map[string]common.Amount{},
+		unaffordable: map[string]int{},
+		inflight:     map[string]InflightTransaction{},
+		expired:      map[string]*expiredTransaction{},
+		events:       newEventBus(),
+		pool:         list.New(),
+	}
+
+	var pool []ReadyAccount
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		ra := ReadyAccount{
+			ID:      strconv.Itoa(i),
+			Type:    operation.TypeCreateAccount,
+			Address: "GA" + strconv.Itoa(i),
+			Balance: common.BaseReserve,
+		}
+
+		reserved, err := am.quota.ReserveAddress(ra.Address, QuotaRule{Window: time.Hour}, ra.Balance)
+		if err != nil {
+			closeDB()
+			t.Fatal(err)
+		}
+		ra.Reserved = reserved
+
+		err = am.journal.Put(&RequestEntry{
+			ID:       ra.ID,
+			Type:     ra.Type,
+			Address:  ra.Address,
+			Balance:  ra.Balance,
+			State:    state,
+			Attempts: attempts,
+			Reserved: ra.Reserved,
+			Created:  now,
+			Updated:  now,
+		})
+		if err != nil {
+			closeDB()
+			t.Fatal(err)
+		}
+		am.pending[ra.Address] = ra
+		pool = append(pool, ra)
+	}
+
+	return am, pool, closeDB
+}
+
+// checkTestRequests checks the journaled state and the quota of the requests
+// and whether they are in pool.
+func checkTestRequests(t *testing.T, am *AccountManager, pool []ReadyAccount, state RequestState, attempts int) {
+	var queued int
+	if state == RequestQueued {
+		queued = len(pool)
+	}
+	if am.pool.Len() != queued {
+		t.Errorf("expected %d in pool; got %d", queued, am.pool.Len())
+	}
+
+	for _, ra := range pool {
+		entry, err := am.journal.Get(ra.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.State != state {
+			t.Errorf("%s: expected state %s; got %s", ra.ID, state, entry.State)
+		}
+		if entry.Attempts != attempts {
+			t.Errorf("%s: expected attempts %d; got %d", ra.ID, attempts, entry.Attempts)
+		}
+
+		// the quota is released only for the failed request.
+		windowed, _, err := am.quota.Usage(quotaAddressPrefix+ra.Address, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if released := windowed == 0; released != (state == RequestFailed) {
+			t.Errorf("%s: expected released=%v; got usage %d", ra.ID, state == RequestFailed, windowed)
+		}
+		if _, found := am.pending[ra.Address]; found != !state.Finished() {
+			t.Errorf("%s: expected pending=%v", ra.ID, !state.Finished())
+		}
+	}
+}
+
+func TestRetryRequests(t *testing.T) {
+	rejected := &TransactionRejectedError{Hash: "tx", Reason: errors.New("rejected")}
+
+	cases := []struct {
+		name     string
+		err      error
+		attempts int
+		state    RequestState
+		// expected is the expected attempts after retrying.
+		expected int
+	}{
+		{name: "network error", err: errors.New("network error"), attempts: 3, state: RequestQueued, expected: 3},
+		{name: "sequence mismatch", err: &TransactionRejectedError{Hash: "tx", SequenceMismatch: true}, attempts: 3, state: RequestQueued, expected: 3},
+		{name: "first rejection", err: rejected, state: RequestQueued, expected: 1},
+		{name: "last retry", err: rejected, attempts: maxTransactionRetries - 1, state: RequestQueued, expected: maxTransactionRetries},
+		{name: "retries exhausted", err: rejected, attempts: maxTransactionRetries, state: RequestFailed, expected: maxTransactionRetries + 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			am, pool, closeDB := newTestJournalManager(t, RequestSubmitted, c.attempts, 2)
+			defer closeDB()
+
+			am.retryRequests(pool, c.err)
+
+			checkTestRequests(t, am, pool, c.state, c.expected)
+		})
+	}
+}
+
+func TestReconcileExpired(t *testing.T) {
+	cases := []struct {
+		name       string
+		status     string
+		sequenceID uint64
+		broken     bool
+		// expired is how long ago the transaction is expired.
+		expired time.Duration
+		state   RequestState
+		// reconciled is false if the transaction is still expired.
+		reconciled bool
+		attempts   int
+	}{
+		{name: "confirmed", status: "confirmed", sequenceID: 11, state: RequestConfirmed, reconciled: true},
+		{name: "dropped", sequenceID: 11, state: RequestQueued, reconciled: true, attempts: 1},
+		{name: "not found", sequenceID: 10, state: RequestExpired},
+		{name: "in pool", status: "submitted", sequenceID: 10, state: RequestExpired},
+		{name: "not found after deadline", sequenceID: 10, expired: expiredDeadline + time.Minute, state: RequestFailed, reconciled: true},
+		{name: "retired source after deadline", expired: expiredDeadline + time.Minute, state: RequestFailed, reconciled: true},
+		{name: "in pool after deadline", status: "submitted", sequenceID: 10, expired: expiredDeadline + time.Minute, state: RequestExpired},
+		{name: "node failed after deadline", sequenceID: 10, broken: true, expired: expiredDeadline + time.Minute, state: RequestExpired},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			node, client, closeNode := newTestNode(t)
+			defer closeNode()
+
+			if c.sequenceID > 0 {
+				node.setAccount("GS", common.BaseReserve, c.sequenceID)
+			}
+			if len(c.status) > 0 {
+				node.setStatus("tx", c.status)
+			}
+			node.broken["tx"] = c.broken
+
+			am, pool, closeDB := newTestJournalManager(t, RequestExpired, 0, 2)
+			defer closeDB()
+			am.client = client
+			am.expired["tx"] = &expiredTransaction{
+				Hash:       "tx",
+				Source:     "GS",
+				SequenceID: 10,
+				Pool:       pool,
+				Expired:    time.Now().Add(-c.expired),
+			}
+
+			am.reconcileExpired()
+
+			if _, found := am.expired["tx"]; found == c.reconciled {
+				t.Errorf("expected reconciled=%v", c.reconciled)
+			}
+			checkTestRequests(t, am, pool, c.state, c.attempts)
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"boscoin.io/sebak/lib/common"
+	"boscoin.io/sebak/lib/network/httputils"
+)
+
+const adminPrefix string = "/admin"
+
+// AdminStatus is the summary of AccountManager.
+type AdminStatus struct {
+	Paused   bool `json:"paused"`
+	Pool     int  `json:"pool"`
+	Sources  int  `json:"sources"`
+	Unused   int  `json:"unused"`
+	Disabled int  `json:"disabled"`
+	Inflight int  `json:"inflight"`
+}
+
+// AdminKeyUsage is the usage of api key.
+type AdminKeyUsage struct {
+	Label       string        `json:"label"`
+	MaxBalance  common.Amount `json:"max_balance"`
+	DailyBudget common.Amount `json:"daily_budget"`
+	Daily       common.Amount `json:"daily"`
+	Lifetime    common.Amount `json:"lifetime"`
+}
+
+type AdminHandler struct {
+	am    *AccountManager
+	token string
+	quota *QuotaStore
+	keys  *APIKeys
+}
+
+func (h *AdminHandler) Router() *mux.Router {
+	router := mux.NewRouter()
+
+	s := router.PathPrefix(adminPrefix).Subrouter()
+	s.Use(h.authMiddleware)
+
+	s.HandleFunc("/status", h.statusHandler).Methods("GET")
+	s.HandleFunc("/sources", h.sourcesHandler).Methods("GET")
+	s.HandleFunc("/sources/reload", h.reloadSourcesHandler).Methods("POST")
+	s.HandleFunc("/sources/{address}/disable", h.disableSourceHandler).Methods("POST")
+	s.HandleFunc("/sources/{address}/enable", h.enableSourceHandler).Methods("POST")
+	s.HandleFunc("/pool", h.poolHandler).Methods("GET")
+	s.HandleFunc("/keys", h.keysHandler).Methods("GET")
+	s.HandleFunc("/transactions", h.transactionsHandler).Methods("GET")
+	s.HandleFunc("/pause", h.pauseHandler).Methods("POST")
+	s.HandleFunc("/resume", h.resumeHandler).Methods("POST")
+	s.HandleFunc("/flush", h.flushHandler).Methods("POST")
+
+	return router
+}
+
+// authMiddleware checks the `Authorization: Bearer <token>` header.
+func (h *AdminHandler) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Bearer ") {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	body, err := common.JSONMarshalIndent(v)
+	if err != nil {
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(body, []byte("\n")...))
+}
+
+func (h *AdminHandler) status() AdminStatus {
+	sources := h.am.Sources()
+
+	status := AdminStatus{
+		Paused:   h.am.Paused(),
+		Pool:     len(h.am.Pool()),
+		Sources:  len(sources),
+		Inflight: len(h.am.Inflight()),
+	}
+	for _, source := range sources {
+		if source.Disabled {
+			status.Disabled++
+		}
+		if !source.Busy {
+			status.Unused++
+		}
+	}
+
+	return status
+}
+
+func (h *AdminHandler) statusHandler(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, h.status())
+}
+
+func (h *AdminHandler) sourcesHandler(w http.ResponseWriter, r *http.Request) {
+	sources := h.am.Sources()
+	if sources == nil {
+		sources = []SourceStatus{}
+	}
+
+	writeAdminJSON(w, sources)
+}
+
+func (h *AdminHandler) setSourceDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	if err := h.am.SetSourceDisabled(mux.Vars(r)["address"], disabled); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	h.sourcesHandler(w, r)
+}
+
+func (h *AdminHandler) disableSourceHandler(w http.ResponseWriter, r *http.Request) {
+	h.setSourceDisabled(w, r, true)
+}
+
+func (h *AdminHandler) enableSourceHandler(w http.ResponseWriter, r *http.Request) {
+	h.setSourceDisabled(w, r, false)
+}
+
+func (h *AdminHandler) reloadSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := h.am.ReloadSources()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeAdminJSON(w, result)
+}
+
+func (h *AdminHandler) poolHandler(w http.ResponseWriter, r *http.Request) {
+	pool := h.am.Pool()
+	if pool == nil {
+		pool = []ReadyAccount{}
+	}
+
+	writeAdminJSON(w, pool)
+}
+
+func (h *AdminHandler) keysHandler(w http.ResponseWriter, r *http.Request) {
+	usages := []AdminKeyUsage{}
+	if h.keys == nil {
+		writeAdminJSON(w, usages)
+		return
+	}
+
+	for _, k := range h.keys.List() {
+		daily, lifetime, err := h.quota.Usage(quotaAPIKeyPrefix+k.Label, apiKeyBudgetDay)
+		if err != nil {
+			httputils.WriteJSONError(w, err)
+			return
+		}
+
+		usages = append(usages, AdminKeyUsage{
+			Label:       k.Label,
+			MaxBalance:  k.MaxBalance,
+			DailyBudget: k.DailyBudget,
+			Daily:       daily,
+			Lifetime:    lifetime,
+		})
+	}
+
+	writeAdminJSON(w, usages)
+}
+
+func (h *AdminHandler) transactionsHandler(w http.ResponseWriter, r *http.Request) {
+	txs := h.am.Inflight()
+	if txs == nil {
+		txs = []InflightTransaction{}
+	}
+
+	writeAdminJSON(w, txs)
+}
+
+func (h *AdminHandler) pauseHandler(w http.ResponseWriter, r *http.Request) {
+	h.am.Pause()
+	writeAdminJSON(w, h.status())
+}
+
+func (h *AdminHandler) resumeHandler(w http.ResponseWriter, r *http.Request) {
+	h.am.Resume()
+	writeAdminJSON(w, h.status())
+}
+
+func (h *AdminHandler) flushHandler(w http.ResponseWriter, r *http.Request) {
+	h.am.Flush()
+	writeAdminJSON(w, h.status())
+}
+
+// listenAdmin opens the listener for admin; the unix socket can be used by
+// `unix:///path/to/socket`.
+func listenAdmin(u *url.URL) (net.Listener, error) {
+	if u.Scheme == "unix" {
+		if _, err := os.Stat(u.Path); err == nil {
+			if err = os.Remove(u.Path); err != nil {
+				return nil, err
+			}
+		}
+
+		l, err := net.Listen("unix", u.Path)
+		if err != nil {
+			return nil, err
+		}
+		if err = os.Chmod(u.Path, 0600); err != nil {
+			l.Close()
+			return nil, err
+		}
+
+		return l, nil
+	}
+
+	return net.Listen("tcp", u.Host)
+}
@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ulule/limiter"
+	"github.com/ulule/limiter/drivers/store/memory"
+	yaml "gopkg.in/yaml.v2"
+
+	"boscoin.io/sebak/lib/common"
+)
+
+const (
+	quotaAPIKeyPrefix string        = "key:"
+	apiKeyBudgetDay   time.Duration = 24 * time.Hour
+)
+
+var (
+	errAPIKeyInvalid  = errors.New("invalid api key")
+	errAPIKeyRequired = errors.New("api key is required")
+)
+
+// APIKey is the client which has it's own limits instead of the defaults for
+// anonymous clients.
+type APIKey struct {
+	Key   string
+	Label string
+	// MaxBalance is the maximum balance of new account and of the account
+	// after payment; 0 follows `--max-balance`.
+	MaxBalance common.Amount
+	// DailyBudget is the maximum amount in the rolling 24 hours; 0 is
+	// unlimited.
+	DailyBudget common.Amount
+
+	limiter *limiter.Limiter
+}
+
+// BudgetRule is the QuotaRule of DailyBudget.
+func (k *APIKey) BudgetRule() QuotaRule {
+	return QuotaRule{Window: apiKeyBudgetDay, WindowAmount: k.DailyBudget}
+}
+
+// apiKeyConfig is the entry of the api keys file.
+type apiKeyConfig struct {
+	Key         string `yaml:"key"`
+	Label       string `yaml:"label"`
+	RateLimit   string `yaml:"rate-limit"`
+	MaxBalance  string `yaml:"max-balance"`
+	DailyBudget string `yaml:"daily-budget"`
+}
+
+// APIKeys is the keys by the hash of key.
+type APIKeys struct {
+	keys map[string]*APIKey
+}
+
+func hashAPIKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// LoadAPIKeys reads the YAML keys file, which has the list of `keys`; each
+// key has `key`, `label`, `rate-limit`, `max-balance` and `daily-budget`.
+func LoadAPIKeys(path string) (*APIKeys, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Keys []apiKeyConfig `yaml:"keys"`
+	}
+	if err = yaml.UnmarshalStrict(b, &config); err != nil {
+		return nil, err
+	}
+
+	store := memory.NewStore()
+
+	keys := &APIKeys{keys: map[string]*APIKey{}}
+	labels := map[string]bool{}
+	for i, c := range config.Keys {
+		if len(c.Key) < 1 {
+			return nil, fmt.Errorf("keys[%d]: empty key", i)
+		}
+		if len(c.Label) < 1 {
+			return nil, fmt.Errorf("keys[%d]: empty label", i)
+		}
+		if labels[c.Label] {
+			return nil, fmt.Errorf("keys[%d]: duplicated label, '%s'", i, c.Label)
+		}
+		labels[c.Label] = true
+
+		k := &APIKey{Key: c.Key, Label: c.Label}
+
+		rate := defaultRateLimit
+		if len(c.RateLimit) > 0 {
+			if rate, err = limiter.NewRateFromFormatted(c.RateLimit); err != nil {
+				return nil, fmt.Errorf("keys[%d]: rate-limit: %v", i, err)
+			}
+		}
+		k.limiter = limiter.New(store, rate)
+
+		if len(c.MaxBalance) > 0 {
+			if k.MaxBalance, err = common.AmountFromString(c.MaxBalance); err != nil {
+				return nil, fmt.Errorf("keys[%d]: max-balance: %v", i, err)
+			}
+		}
+		if len(c.DailyBudget) > 0 {
+			if k.DailyBudget, err = common.AmountFromString(c.DailyBudget); err != nil {
+				return nil, fmt.Errorf("keys[%d]: daily-budget: %v", i, err)
+			}
+		}
+
+		hashed := hashAPIKey(c.Key)
+		if _, found := keys.keys[hashed]; found {
+			return nil, fmt.Errorf("keys[%d]: duplicated key", i)
+		}
+		keys.keys[hashed] = k
+	}
+
+	return keys, nil
+}
+
+func (keys *APIKeys) Get(key string) (*APIKey, bool) {
+	k, found := keys.keys[hashAPIKey(key)]
+	return k, found
+}
+
+// List returns the keys sorted by label.
+func (keys *APIKeys) List() (l []*APIKey) {
+	for _, k := range keys.keys {
+		l = append(l, k)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Label < l[j].Label
+	})
+
+	return
+}
+
+type apiKeyContextKey struct{}
+
+// apiKeyFromRequest returns the APIKey authenticated by apiKeyMiddleware; nil
+// for anonymous.
+func apiKeyFromRequest(r *http.Request) *APIKey {
+	k, _ := r.Context().Value(apiKeyContextKey{}).(*APIKey)
+	return k
+}
+
+// apiKeyMiddleware authenticates `Authorization: Bearer <api key>` and
+// limits the request rate by the key; the requests without `Authorization`
+// go to the fallback, the rate limit middleware by ip address.
+func apiKeyMiddleware(keys *APIKeys, fallback mux.MiddlewareFunc) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		anonymous := fallback(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization := r.Header.Get("Authorization")
+			if len(authorization) < 1 {
+				anonymous.ServeHTTP(w, r)
+				return
+			} else if !strings.HasPrefix(authorization, "Bearer ") {
+				http.Error(w, errAPIKeyInvalid.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			k, found := keys.Get(strings.TrimPrefix(authorization, "Bearer "))
+			if !found {
+				http.Error(w, errAPIKeyInvalid.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			lctx, err := k.limiter.Get(r.Context(), quotaAPIKeyPrefix+k.Label)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(lctx.Limit, 10))
+			w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(lctx.Remaining, 10))
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(lctx.Reset, 10))
+
+			if lctx.Reached {
+				countRequest(outcomeRateLimited)
+				http.Error(w, "Limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+
+			metricKeyRequests.WithLabelValues(k.Label).Inc()
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, k)))
+		})
+	}
+}
+
+// authorize checks the anonymous request is allowed and returns the maximum
+// balance of the request.
+func (h *Handler) authorize(r *http.Request) (*APIKey, common.Amount, error) {
+	k := apiKeyFromRequest(r)
+	if k == nil {
+		if !h.anonymous {
+			return nil, 0, errAPIKeyRequired
+		}
+		return nil, maxBalance, nil
+	}
+
+	if k.MaxBalance > 0 {
+		return k, k.MaxBalance, nil
+	}
+
+	return k, maxBalance, nil
+}
+
+// reserveBudget records the amount to the daily budget of the key; reserved
+// is the key to release it.
+func (h *Handler) reserveBudget(k *APIKey, amount common.Amount) (reserved []string, err error) {
+	if k == nil {
+		return
+	}
+
+	if err = h.quota.Reserve(quotaAPIKeyPrefix+k.Label, k.BudgetRule(), amount); err != nil {
+		return
+	}
+	metricKeyRequested.WithLabelValues(k.Label).Add(float64(amount))
+
+	return []string{quotaAPIKeyPrefix + k.Label}, nil
+}
+
+// reserveQuota reserves the amount from the quota of the address and then
+// from the daily budget of the key; the address quota is released if the
+// budget is exceeded. reserved is the keys to release them.
+func (h *Handler) reserveQuota(k *APIKey, address string, amount common.Amount) (reserved []string, err error) {
+	if reserved, err = h.quota.ReserveAddress(address, h.quotaRule, amount); err != nil {
+		return
+	}
+
+	var budget []string
+	if budget, err = h.reserveBudget(k, amount); err != nil {
+		h.quota.ReleaseKeys(reserved, amount)
+		return nil, err
+	}
+
+	return append(reserved, budget...), nil
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"boscoin.io/sebak/lib/common"
+)
+
+func loadTestAPIKeys(t *testing.T, config string) *APIKeys {
+	dir, err := ioutil.TempDir("", "angelbot-apikeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keys.yml")
+	if err = ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := LoadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return keys
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	cases := []struct {
+		name          string
+		authorization string
+		// requests is the number of requests; the last one is checked.
+		requests  int
+		status    int
+		anonymous bool
+		label     string
+	}{
+		{name: "anonymous", requests: 1, status: http.StatusOK, anonymous: true},
+		{name: "valid key", authorization: "Bearer key-a", requests: 1, status: http.StatusOK, label: "a"},
+		{name: "not bearer", authorization: "Basic key-a", requests: 1, status: http.StatusUnauthorized},
+		{name: "empty bearer", authorization: "Bearer ", requests: 1, status: http.StatusUnauthorized},
+		{name: "unknown key", authorization: "Bearer key-c", requests: 1, status: http.StatusUnauthorized},
+		{name: "in rate limit", authorization: "Bearer key-b", requests: 2, status: http.StatusOK, label: "b"},
+		{name: "rate limited", authorization: "Bearer key-b", requests: 3, status: http.StatusTooManyRequests},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			keys := loadTestAPIKeys(t, `keys:
+  - key: key-a
+    label: a
+  - key: key-b
+    label: b
+    rate-limit: 2-M
+`)
+
+			var anonymous bool
+			fallback := func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					anonymous = true
+					next.ServeHTTP(w, r)
+				})
+			}
+
+			var label string
+			router := mux.NewRouter()
+			router.Use(apiKeyMiddleware(keys, fallback))
+			router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				if k := apiKeyFromRequest(r); k != nil {
+					label = k.Label
+				}
+			})
+
+			var recorder *httptest.ResponseRecorder
+			for i := 0; i < c.requests; i++ {
+				anonymous, label = false, ""
+
+				r := httptest.NewRequest("GET", "/", nil)
+				if len(c.authorization) > 0 {
+					r.Header.Set("Authorization", c.authorization)
+				}
+				recorder = httptest.NewRecorder()
+				router.ServeHTTP(recorder, r)
+			}
+
+			if recorder.Code != c.status {
+				t.Errorf("expected status %d; got %d", c.status, recorder.Code)
+			}
+			if anonymous != c.anonymous {
+				t.Errorf("expected anonymous=%v; got %v", c.anonymous, anonymous)
+			}
+			if label != c.label {
+				t.Errorf("expected key '%s'; got '%s'", c.label, label)
+			}
+		})
+	}
+}
+
+func TestReserveQuota(t *testing.T) {
+	a := common.BaseReserve
+
+	cases := []struct {
+		name      string
+		anonymous bool
+		// budget is the daily budget of the key; 0 is unlimited.
+		budget common.Amount
+		// used is the amount already reserved for the address.
+		used   common.Amount
+		amount common.Amount
+		err    bool
+		// address and key are the expected usages after reserving.
+		address common.Amount
+		key     common.Amount
+	}{
+		{name: "anonymous", anonymous: true, amount: a, address: a},
+		{name: "anonymous over address quota", anonymous: true, amount: 4 * a, err: true},
+		{name: "key", budget: 10 * a, amount: a, address: a, key: a},
+		{name: "unlimited key", budget: 0, amount: 2 * a, address: 2 * a, key: 2 * a},
+		{name: "over budget", budget: a, amount: 2 * a, err: true},
+		{name: "over budget after address quota", budget: a, used: a, amount: 2 * a, err: true, address: a},
+		{name: "over address quota with key", budget: 10 * a, used: 2 * a, amount: 2 * a, err: true, address: 2 * a},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, closeDB := openTestDB(t)
+			defer closeDB()
+
+			h := &Handler{
+				quota:     &QuotaStore{db: db},
+				quotaRule: QuotaRule{Window: time.Hour, WindowAmount: 3 * a},
+			}
+
+			var k *APIKey
+			if !c.anonymous {
+				k = &APIKey{Label: "key", DailyBudget: c.budget}
+			}
+
+			address := "GA"
+			if c.used > 0 {
+				if _, err := h.quota.ReserveAddress(address, h.quotaRule, c.used); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			reserved, err := h.reserveQuota(k, address, c.amount)
+			if c.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if len(reserved) > 0 {
+					t.Errorf("failed reservation returns reserved keys; %v", reserved)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			windowed, _, err := h.quota.Usage(quotaAddressPrefix+address, h.quotaRule.Window)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if windowed != c.address {
+				t.Errorf("expected address usage %d; got %d", c.address, windowed)
+			}
+
+			if k == nil {
+				return
+			}
+			windowed, _, err = h.quota.Usage(quotaAPIKeyPrefix+k.Label, apiKeyBudgetDay)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if windowed != c.key {
+				t.Errorf("expected key usage %d; got %d", c.key, windowed)
+			}
+
+			// the reserved keys release everything reserved.
+			if !c.err {
+				h.quota.ReleaseKeys(reserved, c.amount)
+				if windowed, _, _ = h.quota.Usage(quotaAddressPrefix+address, h.quotaRule.Window); windowed != c.used {
+					t.Errorf("address quota is not released; %d", windowed)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"boscoin.io/sebak/lib/common"
+	"boscoin.io/sebak/lib/errors"
+	"boscoin.io/sebak/lib/network/httputils"
+)
+
+const (
+	maxBulkAccounts     int   = 1000
+	maxBulkRequestBytes int64 = 1 << 20
+	bulkCheckWorkers    int   = 20
+)
+
+// BulkAccount is the entry of `POST /accounts`; the empty balance is the base
+// reserve.
+type BulkAccount struct {
+	Address string `json:"address"`
+	Balance string `json:"balance"`
+}
+
+// BulkAccountResult is the result of each entry of `POST /accounts`; the
+// rejected entry has only Error.
+type BulkAccountResult struct {
+	Address string        `json:"address"`
+	Balance common.Amount `json:"balance,omitempty"`
+	ID      string        `json:"id,omitempty"`
+	State   RequestState  `json:"state,omitempty"`
+	Hash    string        `json:"hash,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+func (r *BulkAccountResult) reject(outcome string, err error) {
+	countRequest(outcome)
+	r.Error = err.Error()
+}
+
+// checkAccountsExist checks the accounts of the results concurrently; the
+// existing accounts are rejected.
+func (h *Handler) checkAccountsExist(results []*BulkAccountResult) {
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, bulkCheckWorkers)
+	for _, result := range results {
+		wg.Add(1)
+		workers <- struct{}{}
+
+		go func(result *BulkAccountResult) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+
+			if _, err := h.getAccount(result.Address); err == nil {
+				result.reject(outcomeAlreadyExists, errors.BlockAccountAlreadyExists)
+			}
+		}(result)
+	}
+	wg.Wait()
+}
+
+// waitRequests waits until all the requests are finished and updates the
+// results by the journal.
+func (h *Handler) waitRequests(closed <-chan bool, results map[string]*BulkAccountResult, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	pending := len(results)
+	for pending > 0 {
+		var stopped bool
+		select {
+		case <-closed:
+			return
+		case <-timer.C:
+			return
+		case <-h.am.Done():
+			// the pool is finished by Stop; the results are updated with the
+			// last states.
+			stopped = true
+		case <-time.After(time.Second * 1):
+		}
+
+		pending = 0
+		for id, result := range results {
+			if result.State.Finished() {
+				continue
+			}
+
+			entry, err := h.am.Request(id)
+			if err != nil {
+				pending++
+				continue
+			}
+			result.State = entry.State
+			result.Hash = entry.Hash
+			result.Error = entry.Error
+
+			if !entry.State.Finished() {
+				pending++
+			}
+		}
+
+		if stopped {
+			return
+		}
+	}
+}
+
+// bulkAccountsHandler creates the accounts of the JSON array of BulkAccount.
+// Each entry is checked like accountHandler; the accepted entries are queued
+// together and the result of every entry is returned in the same order.
+func (h *Handler) bulkAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	setAccessControlHeaders(w)
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	cn, ok := w.(http.CloseNotifier)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	key, limit, err := h.authorize(r)
+	if err != nil {
+		countRequest(outcomeUnauthorized)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// the rate limit and the proof of work are for single address; bulk
+	// request always needs api key, so the route is registered only with
+	// api keys.
+	if key == nil {
+		countRequest(outcomeUnauthorized)
+		http.Error(w, errAPIKeyRequired.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var timeout time.Duration
+	if timeout, err = parseTimeout(r); err != nil {
+		countRequest(outcomeInvalid)
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	var async bool
+	if async, err = isAsync(r); err != nil {
+		countRequest(outcomeInvalid)
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	var options RequestOptions
+	if options, err = h.requestOptions(r); err != nil {
+		countRequest(outcomeInvalid)
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	var entries []BulkAccount
+	if err = json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBulkRequestBytes)).Decode(&entries); err != nil {
+		countRequest(outcomeInvalid)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(entries) < 1 {
+		countRequest(outcomeInvalid)
+		http.Error(w, "accounts are empty", http.StatusBadRequest)
+		return
+	} else if len(entries) > maxBulkAccounts {
+		countRequest(outcomeInvalid)
+		http.Error(w, fmt.Sprintf("too many accounts; maximum is %d", maxBulkAccounts), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]*BulkAccountResult, len(entries))
+	var checked []*BulkAccountResult
+	seen := map[string]bool{}
+	for i, entry := range entries {
+		result := &BulkAccountResult{Address: entry.Address}
+		results[i] = result
+
+		balance := common.BaseReserve
+		if len(entry.Balance) > 0 {
+			if balance, err = common.AmountFromString(entry.Balance); err != nil {
+				result.reject(outcomeInvalid, err)
+				continue
+			}
+		}
+		result.Balance = balance
+
+		if balance < common.BaseReserve {
+			result.reject(outcomeUnderflow, errors.OperationAmountUnderflow)
+			continue
+		} else if balance > limit {
+			result.reject(outcomeOverflow, errors.OperationAmountOverflow)
+			continue
+		}
+
+		if err = checkAddress(entry.Address); err != nil {
+			result.reject(outcomeInvalid, err)
+			continue
+		}
+		if seen[entry.Address] {
+			result.reject(outcomeInvalid, fmt.Errorf("duplicated address"))
+			continue
+		}
+		seen[entry.Address] = true
+
+		checked = append(checked, result)
+	}
+
+	h.checkAccountsExist(checked)
+
+	var ras []ReadyAccount
+	var accepted []*BulkAccountResult
+	for _, result := range checked {
+		if len(result.Error) > 0 {
+			continue
+		}
+
+		ras = append(ras, ReadyAccount{Address: result.Address, Balance: result.Balance})
+		accepted = append(accepted, result)
+	}
+
+	// the entry attached to the pending request does not reserve the quota
+	// again.
+	options.Reserve = func(address string, amount common.Amount) ([]string, error) {
+		return h.reserveQuota(key, address, amount)
+	}
+
+	byID := map[string]*BulkAccountResult{}
+	if len(ras) > 0 {
+		var errs []error
+		if ras, errs, err = h.am.CreateAccounts(ras, options); err == errIntakePaused || err == errShuttingDown {
+			countRequest(outcomePaused)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			countRequest(outcomeFailed)
+			httputils.WriteJSONError(w, err)
+			return
+		}
+
+		for i, ra := range ras {
+			if _, conflict := errs[i].(*RequestConflictError); conflict {
+				accepted[i].reject(outcomeConflict, errs[i])
+				continue
+			} else if e, ok := errs[i].(*ReserveError); ok {
+				accepted[i].reject(outcomeQuotaExceeded, e.Err)
+				continue
+			} else if errs[i] != nil {
+				accepted[i].reject(outcomeFailed, errs[i])
+				continue
+			}
+
+			accepted[i].ID = ra.ID
+			accepted[i].State = RequestQueued
+			byID[ra.ID] = accepted[i]
+		}
+	}
+
+	log.Debug("bulk accounts queued", "accounts", len(entries), "queued", len(byID))
+
+	// 202 only when the entries are queued and not waited.
+	statusCode := http.StatusOK
+	if len(byID) < 1 {
+		statusCode = http.StatusUnprocessableEntity
+	} else if async {
+		statusCode = http.StatusAccepted
+	} else {
+		h.waitRequests(cn.CloseNotify(), byID, timeout)
+	}
+
+	for _, result := range byID {
+		switch {
+		case async:
+			countRequest(outcomeAccepted)
+		case result.State == RequestConfirmed:
+			countRequest(outcomeCreated)
+		case result.State == RequestFailed:
+			countRequest(outcomeFailed)
+		default:
+			countRequest(outcomeTimeout)
+		}
+	}
+
+	body, err := common.JSONMarshalIndent(results)
+	if err != nil {
+		log.Debug("failed to serialize results", "error", err)
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(statusCode)
+	w.Write(append(body, []byte("\n")...))
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"errors"
+	"time"
+
+	logging "github.com/inconshreveable/log15"
+	"github.com/spf13/cobra"
+	"github.com/stellar/go/keypair"
+	"golang.org/x/net/http2"
+
+	"boscoin.io/sebak/lib/common"
+	"boscoin.io/sebak/lib/network"
+	"boscoin.io/sebak/lib/node"
+)
+
+// addClientFlags adds the flags to connect to SEBAK node with the master
+// account; they are shared by `run` and the offline commands.
+func addClientFlags(c *cobra.Command) {
+	c.Flags().StringVar(&flagSecretSeed, "secret-seed", flagSecretSeed, "secret seed of master account")
+	c.Flags().StringVar(&flagKeystore, "keystore", flagKeystore, "encrypted keystore of master and source secret seeds")
+	c.Flags().StringVar(&flagKeystorePassphraseFile, "keystore-passphrase-file", flagKeystorePassphraseFile, "file of keystore passphrase; without it, SEBAK_KEYSTORE_PASSPHRASE or prompt")
+	c.Flags().StringVar(&flagNetworkID, "network-id", flagNetworkID, "network id")
+	c.Flags().StringVar(&flagLogLevel, "log-level", flagLogLevel, "log level, {crit, error, warn, info, debug}")
+	c.Flags().StringVar(&flagLogOutput, "log-output", flagLogOutput, "set log output file")
+	c.Flags().BoolVar(&flagVerbose, "verbose", flagVerbose, "verbose")
+	c.Flags().StringVar(&flagSEBAKEndpointString, "sebak-endpoint", flagSEBAKEndpointString, "sebak endpoint uri")
+	c.Flags().StringVar(&flagTLSCertFile, "tls-cert", flagTLSCertFile, "tls certificate file")
+	c.Flags().StringVar(&flagTLSKeyFile, "tls-key", flagTLSKeyFile, "tls key file")
+}
+
+// parseFlagsClient parses the flags of addClientFlags, sets the logging and
+// checks the SEBAK node is reachable.
+func parseFlagsClient(c *cobra.Command) {
+	var err error
+
+	if len(flagKeystore) > 0 {
+		unlockKeystore(c)
+	}
+
+	if len(flagNetworkID) < 1 {
+		printFlagsError(c, "--network-id", errors.New("must be given"))
+	}
+	if len(flagSecretSeed) < 1 {
+		printFlagsError(c, "--secret-seed", errors.New("must be given"))
+	}
+
+	var parsedKP keypair.KP
+	parsedKP, err = keypair.Parse(flagSecretSeed)
+	if err != nil {
+		printFlagsError(c, "--secret-seed", err)
+	} else if full, ok := parsedKP.(*keypair.Full); !ok {
+		printFlagsError(c, "--secret-seed", errors.New("must be secret seed"))
+	} else {
+		kp = full
+	}
+
+	if p, err := common.ParseEndpoint(flagSEBAKEndpointString); err != nil {
+		printFlagsError(c, "--sebak-endpoint", err)
+	} else {
+		sebakEndpoint = p
+		flagSEBAKEndpointString = sebakEndpoint.String()
+	}
+
+	queries := sebakEndpoint.Query()
+	queries.Add("TLSCertFile", flagTLSCertFile)
+	queries.Add("TLSKeyFile", flagTLSKeyFile)
+	queries.Add("IdleTimeout", "3s")
+	queries.Add("NodeName", node.MakeAlias(kp.Address()))
+	sebakEndpoint.RawQuery = queries.Encode()
+
+	if logLevel, err = logging.LvlFromString(flagLogLevel); err != nil {
+		printFlagsError(c, "--log-level", err)
+	}
+
+	logHandler := logging.StdoutHandler
+
+	if len(flagLogOutput) < 1 {
+		flagLogOutput = "<stdout>"
+	} else {
+		if logHandler, err = logging.FileHandler(flagLogOutput, logging.JsonFormat()); err != nil {
+			printFlagsError(c, "--log-output", err)
+		}
+	}
+
+	log = logging.New("module", "main")
+	log.SetHandler(logging.LvlFilterHandler(logLevel, logHandler))
+	network.SetLogging(logLevel, logHandler)
+
+	// check node status
+	http2Client, _ := common.NewHTTP2Client(
+		3*time.Second,
+		3*time.Second,
+		false,
+	)
+	client := network.NewHTTP2NetworkClient(sebakEndpoint, http2Client)
+	if _, err := client.GetNodeInfo(); err != nil {
+		printFlagsError(c, "--sebak-endpoint", err)
+	}
+
+	if flagVerbose {
+		http2.VerboseLogs = true
+		verbose = true
+	}
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	yaml "gopkg.in/yaml.v2"
+
+	cmdcommon "boscoin.io/sebak/cmd/sebak/common"
+)
+
+// configSourceSeedsKey is the key of config file for the list of source secret
+// seeds; it can be used with or instead of `--sources`.
+const configSourceSeedsKey string = "source-seeds"
+
+var (
+	// configuredKeys is the flags, which are set by config file.
+	configuredKeys    map[string]bool = map[string]bool{}
+	configSourceSeeds []string
+)
+
+// readConfig reads the YAML or TOML config file by it's extension.
+func readConfig(path string) (m map[string]interface{}, err error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		var b []byte
+		if b, err = ioutil.ReadFile(path); err != nil {
+			return
+		}
+		err = yaml.Unmarshal(b, &m)
+	case ".toml":
+		var tree *toml.Tree
+		if tree, err = toml.LoadFile(path); err != nil {
+			return
+		}
+		m = tree.ToMap()
+	default:
+		err = fmt.Errorf("unknown config format, '%s'; .yml, .yaml or .toml", filepath.Ext(path))
+	}
+
+	return
+}
+
+// envName returns the environment variable name of the flag, ex)
+// 'max-balance' -> 'SEBAK_MAX_BALANCE'.
+func envName(flagName string) string {
+	return "SEBAK_" + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// envSet returns true if the flag is set by the environment variable; the
+// list flags are not read from environment and the empty value is ignored.
+func envSet(f *pflag.Flag) bool {
+	if f.Value.Type() == "list" {
+		return false
+	}
+
+	return len(os.Getenv(envName(f.Name))) > 0
+}
+
+// loadConfig sets the flags from the config file. The keys of config file
+// are the names of flags, and the flag given by command line or environment
+// variable takes precedence over the config file.
+func loadConfig(c *cobra.Command, path string) error {
+	m, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := m[key]
+
+		if key == configSourceSeedsKey {
+			seeds, err := parseConfigSourceSeeds(value)
+			if err != nil {
+				return err
+			}
+			configSourceSeeds = append(configSourceSeeds, seeds...)
+			continue
+		}
+
+		f := c.Flags().Lookup(key)
+		if f == nil && c != runCmd && runCmd.Flags().Lookup(key) != nil {
+			// the other commands share the config file of `run`
+			continue
+		}
+		if f == nil || key == "config" || key == "help" {
+			return fmt.Errorf("key '%s': unknown key", key)
+		}
+		if f.Changed {
+			continue
+		}
+		if envSet(f) {
+			continue
+		}
+
+		if err = setFlagFromConfig(f, value); err != nil {
+			return fmt.Errorf("key '%s': %v", key, err)
+		}
+		configuredKeys[key] = true
+	}
+
+	return nil
+}
+
+func parseConfigSourceSeeds(value interface{}) ([]string, error) {
+	l, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("key '%s': must be list", configSourceSeedsKey)
+	}
+
+	var seeds []string
+	for _, seed := range l {
+		seeds = append(seeds, fmt.Sprint(seed))
+	}
+
+	return seeds, nil
+}
+
+func setFlagFromConfig(f *pflag.Flag, value interface{}) error {
+	if values, ok := value.([]interface{}); ok {
+		if f.Value.Type() != "list" {
+			return fmt.Errorf("must not be list")
+		}
+		for _, v := range values {
+			if err := f.Value.Set(fmt.Sprint(v)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	switch value.(type) {
+	case map[string]interface{}, map[interface{}]interface{}:
+		return fmt.Errorf("must not be map")
+	}
+
+	return f.Value.Set(fmt.Sprint(value))
+}
+
+// printFlagsError is cmdcommon.PrintFlagsError, but points the key of config
+// file if the flag was set by config file.
+func printFlagsError(c *cobra.Command, flagName string, err error) {
+	if key := strings.TrimPrefix(flagName, "--"); configuredKeys[key] {
+		err = fmt.Errorf("%v; set by key '%s' of config file, '%s'", err, key, flagConfig)
+	}
+
+	cmdcommon.PrintFlagsError(c, flagName, err)
+}
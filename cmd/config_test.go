@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	cmdcommon "boscoin.io/sebak/cmd/sebak/common"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "angelbot-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "angelbot.yml")
+	config := []byte("max-balance: '300'\nwebhook-allow-host:\n  - hooks.example.com\n")
+	if err = ioutil.WriteFile(path, config, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		// flag is given by command line.
+		flag string
+		// env is SEBAK_MAX_BALANCE and SEBAK_WEBHOOK_ALLOW_HOST; the flag
+		// of string reads it as default value.
+		env        *string
+		maxBalance string
+		configured bool
+	}{
+		{name: "file", maxBalance: "300", configured: true},
+		{name: "flag over file", flag: "100", maxBalance: "100"},
+		{name: "env over file", env: func(s string) *string { return &s }("200"), maxBalance: "200"},
+		{name: "flag over env", flag: "100", env: func(s string) *string { return &s }("200"), maxBalance: "100"},
+		{name: "empty env", env: func(s string) *string { return &s }(""), maxBalance: "300", configured: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			configuredKeys = map[string]bool{}
+			defer func() {
+				configuredKeys = map[string]bool{}
+			}()
+
+			maxBalance := "0"
+			if c.env != nil {
+				os.Setenv(envName("max-balance"), *c.env)
+				os.Setenv(envName("webhook-allow-host"), "env.example.com")
+				defer os.Unsetenv(envName("max-balance"))
+				defer os.Unsetenv(envName("webhook-allow-host"))
+
+				maxBalance = *c.env
+			}
+
+			var allowHosts cmdcommon.ListFlags
+			command := &cobra.Command{Use: "test"}
+			command.Flags().StringVar(&maxBalance, "max-balance", maxBalance, "")
+			command.Flags().Var(&allowHosts, "webhook-allow-host", "")
+			if len(c.flag) > 0 {
+				if err := command.Flags().Set("max-balance", c.flag); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := loadConfig(command, path); err != nil {
+				t.Fatal(err)
+			}
+
+			if maxBalance != c.maxBalance {
+				t.Errorf("expected max-balance=%s; got %s", c.maxBalance, maxBalance)
+			}
+			if configuredKeys["max-balance"] != c.configured {
+				t.Errorf("expected configured=%v; got %v", c.configured, configuredKeys["max-balance"])
+			}
+			// the list flag is not read from environment.
+			if expected := (cmdcommon.ListFlags{"hooks.example.com"}); !reflect.DeepEqual(allowHosts, expected) {
+				t.Errorf("expected webhook-allow-host=%v; got %v", expected, allowHosts)
+			}
+		})
+	}
+}
+
+func TestLoadConfigUnknownKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "angelbot-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name   string
+		config string
+	}{
+		{name: "unknown key", config: "unknown: 1\n"},
+		{name: "config key", config: "config: other.yml\n"},
+		{name: "map value", config: "max-balance:\n  a: 1\n"},
+		{name: "list to string flag", config: "max-balance:\n  - 1\n"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(dir, "angelbot.yml")
+			if err := ioutil.WriteFile(path, []byte(c.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			var maxBalance, config string
+			command := &cobra.Command{Use: "test"}
+			command.Flags().StringVar(&maxBalance, "max-balance", "", "")
+			command.Flags().StringVar(&config, "config", "", "")
+
+			if err := loadConfig(command, path); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"boscoin.io/sebak/lib/common"
+)
+
+var (
+	ctlCmd *cobra.Command
+
+	flagCtlAdmin    string = common.GetENVValue("SEBAK_ADMIN", "http://localhost:23457")
+	flagCtlJSON     bool   = common.GetENVValue("SEBAK_JSON", "0") == "1"
+	flagCtlInsecure bool   = common.GetENVValue("SEBAK_INSECURE", "0") == "1"
+)
+
+func init() {
+	ctlCmd = &cobra.Command{
+		Use:   "ctl",
+		Short: "control the running sebak-angelbot by admin api",
+		Run: func(c *cobra.Command, args []string) {
+			if len(args) < 1 {
+				c.Usage()
+			}
+		},
+	}
+
+	ctlCmd.PersistentFlags().StringVar(&flagCtlAdmin, "admin", flagCtlAdmin, "admin api address of angelbot, ex) 'http://localhost:23457', 'unix:///tmp/angelbot.sock'")
+	ctlCmd.PersistentFlags().StringVar(&flagAdminToken, "admin-token", flagAdminToken, "bearer token for admin api")
+	ctlCmd.PersistentFlags().BoolVar(&flagCtlJSON, "json", flagCtlJSON, "print the response as JSON")
+	ctlCmd.PersistentFlags().BoolVar(&flagCtlInsecure, "insecure", flagCtlInsecure, "skip verifying the tls certificate of admin api")
+
+	ctlCmd.AddCommand(
+		newCtlCommand("status", "show the status", "GET", "/status", printCtlStatus),
+		newCtlCommand("sources", "list the source accounts", "GET", "/sources", printCtlSources),
+		newCtlCommand("pool", "list the requests in pool", "GET", "/pool", printCtlPool),
+		newCtlCommand("pause", "stop taking new requests", "POST", "/pause", printCtlStatus),
+		newCtlCommand("resume", "resume taking new requests", "POST", "/resume", printCtlStatus),
+		newCtlCommand("flush", "send the requests in pool immediately", "POST", "/flush", printCtlStatus),
+		newCtlCommand("reload", "reload the sources", "POST", "/sources/reload", printCtlReload),
+	)
+
+	rootCmd.AddCommand(ctlCmd)
+}
+
+// newCtlCommand makes the `ctl` subcommand, which requests the admin api and
+// prints the response by the printer or as JSON with `--json`.
+func newCtlCommand(use, short, method, path string, printer func(io.Writer, []byte) error) *cobra.Command {
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.ExactArgs(0),
+		Run: func(c *cobra.Command, args []string) {
+			client, base, err := newAdminClient(flagCtlAdmin, flagCtlInsecure)
+			if err != nil {
+				printFlagsError(c, "--admin", err)
+			}
+
+			body, err := requestAdmin(client, method, base+adminPrefix+path, flagAdminToken)
+			if err != nil {
+				exitWithError(err)
+			}
+
+			if flagCtlJSON {
+				os.Stdout.Write(body)
+				return
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			if err = printer(w, body); err != nil {
+				exitWithError(err)
+			}
+			w.Flush()
+		},
+	}
+}
+
+func exitWithError(err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	os.Exit(1)
+}
+
+// newAdminClient returns the http client for admin api and the base url for
+// the requests; for the unix socket, the client dials to the socket.
+func newAdminClient(admin string, insecure bool) (*http.Client, string, error) {
+	u, err := url.Parse(admin)
+	if err != nil {
+		return nil, "", err
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+	}
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Minute}
+
+	switch u.Scheme {
+	case "http", "https":
+		return client, u.Scheme + "://" + u.Host, nil
+	case "unix":
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", u.Path)
+		}
+		return client, "http://unix", nil
+	default:
+		return nil, "", fmt.Errorf("unknown scheme: '%s'", u.Scheme)
+	}
+}
+
+func requestAdmin(client *http.Client, method, u, token string) ([]byte, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, body)
+	}
+
+	return body, nil
+}
+
+func printCtlStatus(w io.Writer, body []byte) error {
+	var status AdminStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "paused:\t%v\n", status.Paused)
+	fmt.Fprintf(w, "pool:\t%d\n", status.Pool)
+	fmt.Fprintf(w, "sources:\t%d\n", status.Sources)
+	fmt.Fprintf(w, "unused:\t%d\n", status.Unused)
+	fmt.Fprintf(w, "disabled:\t%d\n", status.Disabled)
+	fmt.Fprintf(w, "inflight:\t%d\n", status.Inflight)
+
+	return nil
+}
+
+func printCtlSources(w io.Writer, body []byte) error {
+	var sources []SourceStatus
+	if err := json.Unmarshal(body, &sources); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "ADDRESS\tBALANCE\tBUSY\tDISABLED")
+	for _, s := range sources {
+		fmt.Fprintf(w, "%s\t%s\t%v\t%v\n", s.Address, s.Balance, s.Busy, s.Disabled)
+	}
+
+	return nil
+}
+
+func printCtlPool(w io.Writer, body []byte) error {
+	var pool []ReadyAccount
+	if err := json.Unmarshal(body, &pool); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "ID\tTYPE\tADDRESS\tBALANCE")
+	for _, ra := range pool {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", ra.ID, ra.OperationType(), ra.Address, ra.Balance)
+	}
+
+	return nil
+}
+
+func printCtlReload(w io.Writer, body []byte) error {
+	var result SourcesReload
+	if err := json.Unmarshal(body, &result); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "STATE\tSOURCE")
+	for _, address := range result.Added {
+		fmt.Fprintf(w, "added\t%s\n", address)
+	}
+	for _, address := range result.Retired {
+		fmt.Fprintf(w, "retired\t%s\n", address)
+	}
+	for _, address := range result.Failed {
+		fmt.Fprintf(w, "failed\t%s\n", address)
+	}
+	for _, e := range result.Invalid {
+		fmt.Fprintf(w, "invalid\t%s\n", e)
+	}
+
+	return nil
+}
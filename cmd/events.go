@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"boscoin.io/sebak/lib/common"
+	"boscoin.io/sebak/lib/network/httputils"
+)
+
+const eventStreamKeepAlive time.Duration = 15 * time.Second
+
+// RequestEvent is the change of request state, which is published by
+// AccountManager.
+type RequestEvent struct {
+	ID      string        `json:"id"`
+	Address string        `json:"address"`
+	State   RequestState  `json:"state"`
+	Time    time.Time     `json:"time"`
+	Balance common.Amount `json:"balance,omitempty"`
+	// Position is the position in pool of queued request, starting from 1.
+	Position int    `json:"position,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// eventBus delivers the RequestEvents to the subscribers of the address. The
+// slow subscriber misses the events instead of blocking AccountManager.
+type eventBus struct {
+	sync.RWMutex
+
+	subscribers map[string]map[chan RequestEvent]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: map[string]map[chan RequestEvent]struct{}{}}
+}
+
+// Subscribe returns the channel of events for the address; cancel must be
+// called when it is not used.
+func (b *eventBus) Subscribe(address string) (events chan RequestEvent, cancel func()) {
+	events = make(chan RequestEvent, 16)
+
+	b.Lock()
+	if _, found := b.subscribers[address]; !found {
+		b.subscribers[address] = map[chan RequestEvent]struct{}{}
+	}
+	b.subscribers[address][events] = struct{}{}
+	b.Unlock()
+
+	cancel = func() {
+		b.Lock()
+		defer b.Unlock()
+
+		delete(b.subscribers[address], events)
+		if len(b.subscribers[address]) < 1 {
+			delete(b.subscribers, address)
+		}
+	}
+
+	return
+}
+
+func (b *eventBus) Subscribed(address string) bool {
+	b.RLock()
+	defer b.RUnlock()
+
+	_, found := b.subscribers[address]
+	return found
+}
+
+func (b *eventBus) Publish(event RequestEvent) {
+	b.RLock()
+	defer b.RUnlock()
+
+	for events := range b.subscribers[event.Address] {
+		select {
+		case events <- event:
+		default:
+			log.Debug("event subscriber is too slow; event dropped", "address", event.Address, "id", event.ID)
+		}
+	}
+}
+
+// publishRequests publishes the events of the requests, which have
+// subscribers; the confirmed event has the balance of account.
+func (am *AccountManager) publishRequests(pool []ReadyAccount, state RequestState, hash, source string, err error) {
+	now := time.Now()
+	for _, ra := range pool {
+		if !am.events.Subscribed(ra.Address) {
+			continue
+		}
+
+		event := RequestEvent{
+			ID:      ra.ID,
+			Address: ra.Address,
+			State:   state,
+			Time:    now,
+			Hash:    hash,
+			Source:  source,
+		}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		if state == RequestConfirmed {
+			if ba, err := getAccount(am.client, ra.Address); err == nil {
+				event.Balance = ba.Balance
+			}
+		}
+
+		am.events.Publish(event)
+	}
+}
+
+// SubscribeEvents returns the events of the address and the queued events of
+// the requests, which are already in pool.
+func (am *AccountManager) SubscribeEvents(address string) (events chan RequestEvent, cancel func(), queued []RequestEvent) {
+	am.RLock()
+	defer am.RUnlock()
+
+	events, cancel = am.events.Subscribe(address)
+
+	var position int
+	now := time.Now()
+	for e := am.pool.Front(); e != nil; e = e.Next() {
+		position++
+		ra := e.Value.(ReadyAccount)
+		if ra.Address != address {
+			continue
+		}
+		queued = append(queued, RequestEvent{
+			ID:       ra.ID,
+			Address:  ra.Address,
+			State:    RequestQueued,
+			Time:     now,
+			Position: position,
+		})
+	}
+
+	return
+}
+
+// wantsEventStream checks `Accept: text/event-stream`.
+func wantsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+func writeEvent(w http.ResponseWriter, event RequestEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.State, body)
+	return err
+}
+
+// streamEvents writes the events as Server-Sent Events. With id, the stream
+// is closed when the request is finished; without id, it is kept until the
+// client is gone.
+func (h *Handler) streamEvents(w http.ResponseWriter, r *http.Request, events <-chan RequestEvent, queued []RequestEvent, id string, timeout time.Duration) (last RequestState) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range queued {
+		writeEvent(w, event)
+	}
+	flusher.Flush()
+
+	var timeoutChan <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.am.Done():
+			return
+		case <-timeoutChan:
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event := <-events:
+			if len(id) > 0 && event.ID != id {
+				continue
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+
+			last = event.State
+			if len(id) > 0 && event.State.Finished() {
+				return
+			}
+		}
+	}
+}
+
+// eventsHandler streams the events of the address.
+func (h *Handler) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	setAccessControlHeaders(w)
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	address := mux.Vars(r)["address"]
+	if err := checkAddress(address); err != nil {
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	events, cancel, queued := h.am.SubscribeEvents(address)
+	defer cancel()
+
+	h.streamEvents(w, r, events, queued, "", 0)
+}
@@ -6,10 +6,12 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/stellar/go/keypair"
+	"github.com/syndtr/goleveldb/leveldb"
 
 	"boscoin.io/sebak/lib/block"
 	"boscoin.io/sebak/lib/common"
@@ -25,11 +27,24 @@ const (
 	defaultWaitTimeout time.Duration = 60 * time.Second
 )
 
+var (
+	errRequestTimeout = fmt.Errorf("request could not be verified, timeouted")
+	errRequestClosed  = fmt.Errorf("connection closed")
+	// errRequestUnfinished is for the request, which is not finished when
+	// angelbot is stopped; the state of it can be checked by the job.
+	errRequestUnfinished = fmt.Errorf("angelbot is stopped before the request is finished; check the job later")
+)
+
 type Handler struct {
 	am            *AccountManager
 	kp            *keypair.Full
 	sebakEndpoint *common.Endpoint
 	networkID     []byte
+	quota         *QuotaStore
+	quotaRule     QuotaRule
+	pow           *PowGate
+	anonymous     bool
+	webhooks      *Webhooks
 }
 
 func getHTTP2Client() *common.HTTP2Client {
@@ -86,6 +101,8 @@ func (h *Handler) sendMessage(method, path string, message []byte) (b []byte, er
 }
 
 func getAccount(client *network.HTTP2NetworkClient, address string) (ba *block.BlockAccount, err error) {
+	defer observeUpstream("get-account", time.Now())
+
 	var b []byte
 	if b, err = client.Get("/api/v1/accounts/" + address); err != nil {
 		return
@@ -105,6 +122,8 @@ func getAccount(client *network.HTTP2NetworkClient, address string) (ba *block.B
 }
 
 func (h *Handler) getAccount(address string) (ba *block.BlockAccount, err error) {
+	defer observeUpstream("get-account", time.Now())
+
 	headers := http.Header{}
 	headers.Set("Content-Type", "application/json")
 
@@ -123,13 +142,19 @@ func (h *Handler) getAccount(address string) (ba *block.BlockAccount, err error)
 	return
 }
 
-func createAccountTransaction(networkID []byte, kp *keypair.Full, sequenceID uint64, timeout time.Duration, accounts ...ReadyAccount) (tx transaction.Transaction, err error) {
+func newBatchTransaction(networkID []byte, kp *keypair.Full, sequenceID uint64, timeout time.Duration, accounts ...ReadyAccount) (tx transaction.Transaction, err error) {
 	var ops []operation.Operation
 	for _, ra := range accounts {
-		opb := operation.NewCreateAccount(ra.Address, ra.Balance, "")
+		var opb operation.Body
+		switch ra.Type {
+		case operation.TypePayment:
+			opb = operation.NewPayment(ra.Address, ra.Balance)
+		default:
+			opb = operation.NewCreateAccount(ra.Address, ra.Balance, "")
+		}
 		op := operation.Operation{
 			H: operation.Header{
-				Type: operation.TypeCreateAccount,
+				Type: ra.OperationType(),
 			},
 			B: opb,
 		}
@@ -232,10 +257,216 @@ End:
 	return
 }
 
-func (h *Handler) accountHandler(w http.ResponseWriter, r *http.Request) {
+func setAccessControlHeaders(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, "+powChallengeHeader+", "+powSolutionHeader)
+	w.Header().Set("Access-Control-Expose-Headers", "Location")
+}
+
+// isAsync checks the `async` querystring; if true, the request will not wait
+// until the account is created.
+func isAsync(r *http.Request) (async bool, err error) {
+	s := r.URL.Query().Get("async")
+	if len(s) < 1 {
+		return
+	}
+
+	if async, err = strconv.ParseBool(s); err != nil {
+		err = fmt.Errorf("invalid async format")
+	}
+
+	return
+}
+
+func writeJob(w http.ResponseWriter, statusCode int, entry *RequestEntry) {
+	// the reserved quota keys and the callback urls of the other clients are
+	// internal.
+	job := *entry
+	job.Reserved = nil
+	job.AttachedCallbackURLs = nil
+
+	body, err := common.JSONMarshalIndent(job)
+	if err != nil {
+		log.Debug("failed to serialize job", "error", err)
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(statusCode)
+	w.Write(append(body, []byte("\n")...))
+}
+
+func (h *Handler) writeAccepted(w http.ResponseWriter, ra ReadyAccount) {
+	entry, err := h.am.Request(ra.ID)
+	if err != nil {
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+ra.ID)
+	writeJob(w, http.StatusAccepted, entry)
+}
+
+func parseTimeout(r *http.Request) (timeout time.Duration, err error) {
+	timeout = defaultWaitTimeout
+	if timeoutString, found := r.URL.Query()["timeout"]; found && len(timeoutString) > 0 && len(timeoutString[0]) > 0 {
+		if timeout, err = time.ParseDuration(timeoutString[0]); err != nil {
+			err = fmt.Errorf("invalid timeout format")
+			return
+		}
+	}
+
+	return
+}
+
+func checkAddress(address string) error {
+	parsedKP, err := keypair.Parse(address)
+	if err != nil {
+		return err
+	} else if _, ok := parsedKP.(*keypair.Full); ok {
+		return fmt.Errorf("don't provide secret seed; PLEASE!!!")
+	}
+
+	return nil
+}
+
+// requestOptions parses the optional querystrings of request; `callback_url`
+// is allowed only when the webhooks are enabled.
+func (h *Handler) requestOptions(r *http.Request) (options RequestOptions, err error) {
+	callbackURL := r.URL.Query().Get("callback_url")
+	if len(callbackURL) < 1 {
+		return
+	}
+
+	if h.webhooks == nil {
+		err = fmt.Errorf("callback_url is not allowed")
+		return
+	}
+	if err = h.webhooks.CheckURL(callbackURL); err != nil {
+		return
+	}
+	options.CallbackURL = callbackURL
+
+	return
+}
+
+// countWaitError counts the outcome of the request, which is not finished
+// by waitRequest.
+func countWaitError(err error) {
+	switch err {
+	case errRequestTimeout:
+		countRequest(outcomeTimeout)
+	case errRequestClosed:
+		countRequest(outcomeClosed)
+	case errRequestUnfinished:
+		countRequest(outcomePaused)
+	default:
+		countRequest(outcomeFailed)
+	}
+}
+
+// waitRequest waits until the request is confirmed or failed.
+func (h *Handler) waitRequest(closed <-chan bool, id string, timeout time.Duration) (entry *RequestEntry, err error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-closed:
+			err = errRequestClosed
+			return
+		case <-timer.C:
+			err = errRequestTimeout
+			return
+		case <-h.am.Done():
+			// the pool is finished by Stop; the request is answered with the
+			// last state.
+			if entry, err = h.am.Request(id); err != nil {
+				return
+			}
+
+			switch entry.State {
+			case RequestConfirmed:
+			case RequestFailed:
+				err = fmt.Errorf("request failed: %s", entry.Error)
+			default:
+				err = errRequestUnfinished
+			}
+			return
+		case <-time.After(time.Second * 1):
+			if entry, err = h.am.Request(id); err != nil {
+				return
+			}
+
+			switch entry.State {
+			case RequestConfirmed:
+				return
+			case RequestFailed:
+				err = fmt.Errorf("request failed: %s", entry.Error)
+				return
+			}
+		}
+	}
+}
+
+func (h *Handler) jobHandler(w http.ResponseWriter, r *http.Request) {
+	setAccessControlHeaders(w)
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	entry, err := h.am.Request(mux.Vars(r)["id"])
+	if err == leveldb.ErrNotFound {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	writeJob(w, http.StatusOK, entry)
+}
+
+func (h *Handler) challengeHandler(w http.ResponseWriter, r *http.Request) {
+	setAccessControlHeaders(w)
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	challenge, err := h.pow.Challenge()
+	if err != nil {
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	var body []byte
+	if body, err = common.JSONMarshalIndent(challenge); err != nil {
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Write(append(body, []byte("\n")...))
+}
+
+// countStreamed counts the outcome of request by the last event.
+func countStreamed(last RequestState, confirmed string) {
+	switch last {
+	case RequestConfirmed:
+		countRequest(confirmed)
+	case RequestFailed:
+		countRequest(outcomeFailed)
+	default:
+		countRequest(outcomeTimeout)
+	}
+}
+
+func (h *Handler) accountHandler(w http.ResponseWriter, r *http.Request) {
+	setAccessControlHeaders(w)
 
 	if r.Method == "OPTIONS" {
 		return
@@ -251,105 +482,323 @@ func (h *Handler) accountHandler(w http.ResponseWriter, r *http.Request) {
 
 	var err error
 
+	key, limit, err := h.authorize(r)
+	if err != nil {
+		countRequest(outcomeUnauthorized)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
 	// balance
 	balance := common.BaseReserve
 	if balanceString, found := r.URL.Query()["balance"]; found && len(balanceString) > 0 && len(balanceString[0]) > 0 {
 		if balance, err = common.AmountFromString(balanceString[0]); err != nil {
+			countRequest(outcomeInvalid)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 	}
 	if balance < common.BaseReserve {
+		countRequest(outcomeUnderflow)
 		httputils.WriteJSONError(w, errors.OperationAmountUnderflow)
 		return
-	} else if balance > maxBalance {
+	} else if balance > limit {
+		countRequest(outcomeOverflow)
 		httputils.WriteJSONError(w, errors.OperationAmountOverflow)
 		return
 	}
 
 	// timeout
-	timeout := defaultWaitTimeout
-	if timeoutString, found := r.URL.Query()["timeout"]; found && len(timeoutString) > 0 && len(timeoutString[0]) > 0 {
-		if timeout, err = time.ParseDuration(timeoutString[0]); err != nil {
-			httputils.WriteJSONError(w, fmt.Errorf("invalid timeout format"))
-			return
-		}
+	var timeout time.Duration
+	if timeout, err = parseTimeout(r); err != nil {
+		countRequest(outcomeInvalid)
+		httputils.WriteJSONError(w, err)
+		return
 	}
 
-	// check address is valid
-	var parsedKP keypair.KP
-	if parsedKP, err = keypair.Parse(address); err != nil {
+	var async bool
+	if async, err = isAsync(r); err != nil {
+		countRequest(outcomeInvalid)
 		httputils.WriteJSONError(w, err)
 		return
-	} else if _, ok := parsedKP.(*keypair.Full); ok {
-		httputils.WriteJSONError(w, fmt.Errorf("don't provide secret seed; PLEASE!!!"))
+	}
+
+	var options RequestOptions
+	if options, err = h.requestOptions(r); err != nil {
+		countRequest(outcomeInvalid)
+		httputils.WriteJSONError(w, err)
 		return
 	}
 
+	// check address is valid
+	if err = checkAddress(address); err != nil {
+		countRequest(outcomeInvalid)
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	if h.pow != nil && key == nil {
+		if err = h.pow.VerifyRequest(r, address); err != nil {
+			countRequest(outcomePowFailed)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
 	// check account exists
 	if _, err = h.getAccount(address); err == nil {
+		countRequest(outcomeAlreadyExists)
 		http.Error(w, "account is already exists", http.StatusBadRequest)
 		httputils.WriteJSONError(w, errors.BlockAccountAlreadyExists)
 		return
 	}
 
-	h.am.CreateAccount(address, balance)
+	// the request attached to the pending one does not reserve the quota
+	// again.
+	options.Reserve = func(address string, amount common.Amount) ([]string, error) {
+		return h.reserveQuota(key, address, amount)
+	}
 
-	var baCreated *block.BlockAccount
+	// with `Accept: text/event-stream`, subscribe before the request is
+	// queued, so no event is missed.
+	var events chan RequestEvent
+	if !async && wantsEventStream(r) {
+		var cancel func()
+		events, cancel, _ = h.am.SubscribeEvents(address)
+		defer cancel()
+	}
 
-	timer := time.NewTimer(timeout)
+	var ra ReadyAccount
+	if ra, err = h.am.CreateAccount(address, balance, options); err == errIntakePaused || err == errShuttingDown {
+		countRequest(outcomePaused)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if _, conflict := err.(*RequestConflictError); conflict {
+		countRequest(outcomeConflict)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if e, ok := err.(*ReserveError); ok {
+		countRequest(outcomeQuotaExceeded)
+		httputils.WriteJSONError(w, e.Err)
+		return
+	} else if err != nil {
+		countRequest(outcomeFailed)
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	if async {
+		countRequest(outcomeAccepted)
+		h.writeAccepted(w, ra)
+		return
+	}
+
+	if events != nil {
+		countStreamed(h.streamEvents(w, r, events, nil, ra.ID, timeout), outcomeCreated)
+		return
+	}
 
 	log.Debug("checking new account", "address", address)
-	var created bool
-	for {
-		select {
-		case <-cn.CloseNotify():
-			goto End
-		case <-timer.C:
-			err = fmt.Errorf("account could be verified, timeouted")
-			goto End
-		default:
-			time.Sleep(time.Second * 1)
 
-			// check BlockTransactionHistory
-			if baCreated, err = h.getAccount(address); err == nil {
-				if baCreated.Balance != balance {
-					err = fmt.Errorf("failed to create account")
-					log.Error(
-						"failed to create new account, balance mismatch",
-						"address", address,
-						"created balance", baCreated.Balance,
-						"expected balance", balance,
-					)
-				} else {
-					created = true
-					log.Debug("new account is created successfully", "address", address)
-				}
+	var baCreated *block.BlockAccount
+	var entry *RequestEntry
+	if entry, err = h.waitRequest(cn.CloseNotify(), ra.ID, timeout); err == nil {
+		baCreated, err = h.getAccount(address)
+	}
 
-				goto End
-			}
-		}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err == errRequestUnfinished {
+		countWaitError(err)
+		w.Header().Set("Location", "/jobs/"+ra.ID)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		httputils.WriteJSONError(w, err)
+		return
+	} else if err != nil {
+		countWaitError(err)
+		w.WriteHeader(http.StatusOK)
+		httputils.WriteJSONError(w, err)
+		return
 	}
 
-End:
-	if created {
-		err = nil
+	countRequest(outcomeCreated)
+	log.Debug("new account is created successfully", "address", address, "hash", entry.Hash)
+
+	var body []byte
+	if body, err = common.JSONMarshalIndent(baCreated); err != nil {
+		log.Debug("failed to serialize BlockAccount", "error", err)
+		httputils.WriteJSONError(w, err)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(append(body, []byte("\n")...))
+}
+
+func (h *Handler) paymentHandler(w http.ResponseWriter, r *http.Request) {
+	setAccessControlHeaders(w)
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	cn, ok := w.(http.CloseNotifier)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	address := mux.Vars(r)["address"]
+
+	var err error
+
+	key, limit, err := h.authorize(r)
 	if err != nil {
+		countRequest(outcomeUnauthorized)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// amount
+	amountString := r.URL.Query().Get("amount")
+	if len(amountString) < 1 {
+		countRequest(outcomeInvalid)
+		httputils.WriteJSONError(w, fmt.Errorf("amount must be given"))
+		return
+	}
+
+	var amount common.Amount
+	if amount, err = common.AmountFromString(amountString); err != nil {
+		countRequest(outcomeInvalid)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if amount < 1 {
+		countRequest(outcomeUnderflow)
+		httputils.WriteJSONError(w, errors.OperationAmountUnderflow)
+		return
+	} else if amount > limit {
+		countRequest(outcomeOverflow)
+		httputils.WriteJSONError(w, errors.OperationAmountOverflow)
+		return
+	}
+
+	var timeout time.Duration
+	if timeout, err = parseTimeout(r); err != nil {
+		countRequest(outcomeInvalid)
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	var async bool
+	if async, err = isAsync(r); err != nil {
+		countRequest(outcomeInvalid)
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	var options RequestOptions
+	if options, err = h.requestOptions(r); err != nil {
+		countRequest(outcomeInvalid)
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	if err = checkAddress(address); err != nil {
+		countRequest(outcomeInvalid)
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	// the account should exist and can not be funded over max balance with
+	// the unfinished payments; it is checked again by Pay.
+	var ba *block.BlockAccount
+	if ba, err = h.getAccount(address); err != nil {
+		countRequest(outcomeNotFound)
+		httputils.WriteJSONError(w, errors.BlockAccountDoesNotExists)
+		return
+	}
+	if ba.Balance > limit || amount > limit-ba.Balance {
+		countRequest(outcomeOverflow)
+		httputils.WriteJSONError(w, errors.OperationAmountOverflow)
+		return
+	}
+	options.Balance = ba.Balance
+	options.MaxBalance = limit
+
+	options.Reserve = func(address string, amount common.Amount) ([]string, error) {
+		return h.reserveQuota(key, address, amount)
+	}
+
+	// with `Accept: text/event-stream`, subscribe before the request is
+	// queued, so no event is missed.
+	var events chan RequestEvent
+	if !async && wantsEventStream(r) {
+		var cancel func()
+		events, cancel, _ = h.am.SubscribeEvents(address)
+		defer cancel()
+	}
+
+	var ra ReadyAccount
+	if ra, err = h.am.Pay(address, amount, options); err == errIntakePaused || err == errShuttingDown {
+		countRequest(outcomePaused)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err == errors.OperationAmountOverflow {
+		countRequest(outcomeOverflow)
+		httputils.WriteJSONError(w, err)
+		return
+	} else if e, ok := err.(*ReserveError); ok {
+		countRequest(outcomeQuotaExceeded)
+		httputils.WriteJSONError(w, e.Err)
+		return
+	} else if err != nil {
+		countRequest(outcomeFailed)
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	if async {
+		countRequest(outcomeAccepted)
+		h.writeAccepted(w, ra)
+		return
+	}
+
+	if events != nil {
+		countStreamed(h.streamEvents(w, r, events, nil, ra.ID, timeout), outcomePaid)
+		return
+	}
+
+	log.Debug("checking payment", "address", address, "amount", amount)
+
+	var entry *RequestEntry
+	if entry, err = h.waitRequest(cn.CloseNotify(), ra.ID, timeout); err == nil {
+		ba, err = h.getAccount(address)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err == errRequestUnfinished {
+		countWaitError(err)
+		w.Header().Set("Location", "/jobs/"+ra.ID)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		httputils.WriteJSONError(w, err)
+		return
+	} else if err != nil {
+		countWaitError(err)
 		w.WriteHeader(http.StatusOK)
 		httputils.WriteJSONError(w, err)
-	} else {
-		w.WriteHeader(http.StatusCreated)
+		return
+	}
 
-		var body []byte
-		if body, err = common.JSONMarshalIndent(baCreated); err != nil {
-			log.Debug("failed to serialize BlockAccount", "error", err)
-			httputils.WriteJSONError(w, err)
-			return
-		}
+	countRequest(outcomePaid)
+	log.Debug("account is paid successfully", "address", address, "hash", entry.Hash)
 
-		w.Write(append(body, []byte("\n")...))
+	var body []byte
+	if body, err = common.JSONMarshalIndent(ba); err != nil {
+		log.Debug("failed to serialize BlockAccount", "error", err)
+		httputils.WriteJSONError(w, err)
+		return
 	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(body, []byte("\n")...))
 }
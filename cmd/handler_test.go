@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"container/list"
+	"testing"
+	"time"
+
+	logging "github.com/inconshreveable/log15"
+)
+
+func init() {
+	log = logging.New()
+	log.SetHandler(logging.DiscardHandler())
+}
+
+func TestWaitRequestOnStop(t *testing.T) {
+	cases := []struct {
+		name string
+		// state is the state of request, which is finished while Stop is
+		// draining.
+		state RequestState
+		err   bool
+		// unfinished expects errRequestUnfinished.
+		unfinished bool
+	}{
+		{name: "confirmed while draining", state: RequestConfirmed},
+		{name: "failed while draining", state: RequestFailed, err: true},
+		{name: "unfinished", state: RequestSubmitted, err: true, unfinished: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, closeDB := openTestDB(t)
+			defer closeDB()
+			journal := &Journal{db: db}
+
+			now := time.Now()
+			if err := journal.Put(&RequestEntry{ID: "a", State: RequestBatched, Created: now, Updated: now}); err != nil {
+				t.Fatal(err)
+			}
+
+			am := &AccountManager{
+				journal: journal,
+				pool:    list.New(),
+				stopped: make(chan struct{}),
+				done:    make(chan struct{}),
+				// the transaction of the request is in flight.
+				running: 1,
+			}
+			h := &Handler{am: am}
+
+			type result struct {
+				entry *RequestEntry
+				err   error
+			}
+			results := make(chan result, 1)
+			go func() {
+				entry, err := h.waitRequest(nil, "a", time.Minute)
+				results <- result{entry: entry, err: err}
+			}()
+
+			am.Close()
+			stopped := make(chan error, 1)
+			go func() {
+				stopped <- am.Stop(10 * time.Second)
+			}()
+
+			// the waiting client is not answered while draining.
+			select {
+			case r := <-results:
+				t.Fatalf("answered before request is finished; %v", r.err)
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			if _, err := journal.Update("a", func(entry *RequestEntry) {
+				entry.State = c.state
+			}); err != nil {
+				t.Fatal(err)
+			}
+			am.Lock()
+			am.running--
+			am.Unlock()
+
+			select {
+			case err := <-stopped:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("not stopped")
+			}
+
+			var r result
+			select {
+			case r = <-results:
+			case <-time.After(5 * time.Second):
+				t.Fatal("not answered after stopped")
+			}
+
+			if c.err && r.err == nil {
+				t.Fatal("expected error")
+			} else if !c.err && r.err != nil {
+				t.Fatal(r.err)
+			}
+			if (r.err == errRequestUnfinished) != c.unfinished {
+				t.Errorf("expected unfinished=%v; got %v", c.unfinished, r.err)
+			}
+			if !c.err && r.entry.State != c.state {
+				t.Errorf("expected state=%s; got %s", c.state, r.entry.State)
+			}
+		})
+	}
+}
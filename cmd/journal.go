@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"boscoin.io/sebak/lib/common"
+	"boscoin.io/sebak/lib/transaction/operation"
+)
+
+const journalRequestPrefix string = "request-"
+
+type RequestState string
+
+const (
+	RequestQueued    RequestState = "queued"
+	RequestBatched   RequestState = "batched"
+	RequestSubmitted RequestState = "submitted"
+	// RequestExpired is sent, but the transaction is not confirmed in time;
+	// it is checked again until it is confirmed or proven to be dropped.
+	RequestExpired   RequestState = "expired"
+	RequestConfirmed RequestState = "confirmed"
+	RequestFailed    RequestState = "failed"
+)
+
+// Finished returns true when the request will not be processed any more.
+func (s RequestState) Finished() bool {
+	return s == RequestConfirmed || s == RequestFailed
+}
+
+// RequestEntry is the journaled state of one ReadyAccount.
+type RequestEntry struct {
+	ID      string                  `json:"id"`
+	Type    operation.OperationType `json:"type,omitempty"`
+	Address string                  `json:"address"`
+	Balance common.Amount           `json:"balance"`
+	State   RequestState            `json:"state"`
+	Hash    string                  `json:"hash,omitempty"`
+	Source  string                  `json:"source,omitempty"`
+	// SequenceID is the sequence id of the transaction, which includes the
+	// request.
+	SequenceID uint64 `json:"sequence_id,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// Attempts is the number of rejected transactions of the request.
+	Attempts int `json:"attempts,omitempty"`
+	// CallbackURL receives the webhook when the request is finished.
+	CallbackURL string `json:"callback_url,omitempty"`
+	// AttachedCallbackURLs is the callback urls of the requests attached to
+	// this one.
+	AttachedCallbackURLs []string `json:"attached_callback_urls,omitempty"`
+	// Reserved is the quota keys reserved for the request; they are released
+	// when the request fails.
+	Reserved []string  `json:"reserved,omitempty"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+func (e *RequestEntry) ReadyAccount() ReadyAccount {
+	return ReadyAccount{
+		ID:      e.ID,
+		Type:    e.Type,
+		Address: e.Address,
+		Balance: e.Balance,
+
+		Reserved: e.Reserved,
+	}
+}
+
+// Journal keeps the requests of AccountManager on disk, so the queued
+// requests can be replayed after restarting.
+type Journal struct {
+	sync.Mutex
+
+	db *leveldb.DB
+}
+
+func OpenJournal(path string) (*Journal, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Journal{db: db}, nil
+}
+
+func (j *Journal) Close() error {
+	return j.db.Close()
+}
+
+func (j *Journal) Put(entry *RequestEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return j.db.Put([]byte(journalRequestPrefix+entry.ID), b, nil)
+}
+
+func (j *Journal) Get(id string) (entry *RequestEntry, err error) {
+	var b []byte
+	if b, err = j.db.Get([]byte(journalRequestPrefix+id), nil); err != nil {
+		return
+	}
+
+	err = json.Unmarshal(b, &entry)
+	return
+}
+
+// Update loads the entry, applies f and stores it again; the updated entry
+// is returned.
+func (j *Journal) Update(id string, f func(*RequestEntry)) (*RequestEntry, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	entry, err := j.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	f(entry)
+	entry.Updated = time.Now()
+
+	return entry, j.Put(entry)
+}
+
+// Unfinished returns the entries which are not confirmed or failed yet, in
+// the order of creation.
+func (j *Journal) Unfinished() (entries []*RequestEntry, err error) {
+	iter := j.db.NewIterator(util.BytesPrefix([]byte(journalRequestPrefix)), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		var entry *RequestEntry
+		if err = json.Unmarshal(iter.Value(), &entry); err != nil {
+			return
+		}
+		if entry.State.Finished() {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+	if err = iter.Error(); err != nil {
+		return
+	}
+
+	sort.Slice(entries, func(i, k int) bool {
+		return entries[i].Created.Before(entries[k].Created)
+	})
+
+	return
+}
+
+// Prune deletes the finished entries, which are not updated since before; it
+// returns the number of deleted entries.
+func (j *Journal) Prune(before time.Time) (pruned int, err error) {
+	iter := j.db.NewIterator(util.BytesPrefix([]byte(journalRequestPrefix)), nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		var entry *RequestEntry
+		if err = json.Unmarshal(iter.Value(), &entry); err != nil {
+			return
+		}
+		if !entry.State.Finished() || !entry.Updated.Before(before) {
+			continue
+		}
+
+		batch.Delete(append([]byte(nil), iter.Key()...))
+		pruned++
+	}
+	if err = iter.Error(); err != nil {
+		return
+	}
+
+	if batch.Len() < 1 {
+		return
+	}
+
+	err = j.db.Write(batch, &opt.WriteOptions{Sync: true})
+	return
+}
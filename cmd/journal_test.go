@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// openTestDB opens the leveldb in the temporary directory for the stores of
+// test; the returned func closes and removes it.
+func openTestDB(t *testing.T) (*leveldb.DB, func()) {
+	dir, err := ioutil.TempDir("", "angelbot-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestJournalUpdate(t *testing.T) {
+	db, closeDB := openTestDB(t)
+	defer closeDB()
+	journal := &Journal{db: db}
+
+	created := time.Now().Add(-time.Minute)
+	if err := journal.Put(&RequestEntry{ID: "a", State: RequestQueued, Created: created, Updated: created}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		id    string
+		state RequestState
+		hash  string
+		err   bool
+	}{
+		{name: "batched", id: "a", state: RequestBatched, hash: "hash-0"},
+		{name: "expired", id: "a", state: RequestExpired, hash: "hash-0"},
+		{name: "confirmed", id: "a", state: RequestConfirmed, hash: "hash-1"},
+		{name: "unknown", id: "b", err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			entry, err := journal.Update(c.id, func(entry *RequestEntry) {
+				entry.State = c.state
+				entry.Hash = c.hash
+			})
+			if c.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !entry.Updated.After(created) {
+				t.Errorf("updated is not changed; %s", entry.Updated)
+			}
+
+			stored, err := journal.Get(c.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.State != c.state || stored.Hash != c.hash {
+				t.Errorf("expected state=%s hash=%s; got state=%s hash=%s", c.state, c.hash, stored.State, stored.Hash)
+			}
+		})
+	}
+}
+
+func TestJournalUnfinished(t *testing.T) {
+	db, closeDB := openTestDB(t)
+	defer closeDB()
+	journal := &Journal{db: db}
+
+	now := time.Now()
+	entries := []*RequestEntry{
+		{ID: "d", State: RequestQueued, Created: now.Add(-1 * time.Minute)},
+		{ID: "c", State: RequestConfirmed, Created: now.Add(-2 * time.Minute)},
+		{ID: "b", State: RequestExpired, Created: now.Add(-3 * time.Minute)},
+		{ID: "a", State: RequestFailed, Created: now.Add(-4 * time.Minute)},
+		{ID: "e", State: RequestSubmitted, Created: now.Add(-5 * time.Minute)},
+		{ID: "f", State: RequestBatched, Created: now.Add(-6 * time.Minute)},
+	}
+	for _, entry := range entries {
+		if err := journal.Put(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	unfinished, err := journal.Unfinished()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"f", "e", "b", "d"}
+	if len(unfinished) != len(expected) {
+		t.Fatalf("expected %d entries; got %d", len(expected), len(unfinished))
+	}
+	for i, id := range expected {
+		if unfinished[i].ID != id {
+			t.Errorf("%d: expected %s; got %s", i, id, unfinished[i].ID)
+		}
+	}
+}
+
+func TestJournalPrune(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		name    string
+		state   RequestState
+		updated time.Time
+		pruned  bool
+	}{
+		{name: "old confirmed", state: RequestConfirmed, updated: now.Add(-2 * time.Hour), pruned: true},
+		{name: "old failed", state: RequestFailed, updated: now.Add(-2 * time.Hour), pruned: true},
+		{name: "new confirmed", state: RequestConfirmed, updated: now},
+		{name: "old queued", state: RequestQueued, updated: now.Add(-2 * time.Hour)},
+		{name: "old expired", state: RequestExpired, updated: now.Add(-2 * time.Hour)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, closeDB := openTestDB(t)
+			defer closeDB()
+			journal := &Journal{db: db}
+
+			if err := journal.Put(&RequestEntry{ID: "a", State: c.state, Created: c.updated, Updated: c.updated}); err != nil {
+				t.Fatal(err)
+			}
+
+			pruned, err := journal.Prune(now.Add(-time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := 0
+			if c.pruned {
+				expected = 1
+			}
+			if pruned != expected {
+				t.Errorf("expected pruned=%d; got %d", expected, pruned)
+			}
+
+			_, err = journal.Get("a")
+			if c.pruned && err == nil {
+				t.Error("entry is not deleted")
+			} else if !c.pruned && err != nil {
+				t.Errorf("entry is deleted; %v", err)
+			}
+		})
+	}
+}
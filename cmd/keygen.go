@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/stellar/go/keypair"
+)
+
+var (
+	keygenCmd *cobra.Command
+
+	flagKeygenCount    int
+	flagKeygenOutput   string
+	flagKeygenForce    bool
+	flagKeygenAnnotate bool
+)
+
+func init() {
+	keygenCmd = &cobra.Command{
+		Use:   "keygen",
+		Short: "generate keypairs for --sources file",
+		Args:  cobra.ExactArgs(0),
+		Run: func(c *cobra.Command, args []string) {
+			if flagKeygenCount < 1 {
+				printFlagsError(c, "--count", errors.New("must be greater than 0"))
+			}
+			if len(flagKeygenOutput) < 1 {
+				printFlagsError(c, "--output", errors.New("must be given"))
+			}
+
+			if err := keygen(flagKeygenOutput, flagKeygenCount, flagKeygenForce, flagKeygenAnnotate); err != nil {
+				printFlagsError(c, "--output", err)
+			}
+		},
+	}
+
+	keygenCmd.Flags().IntVarP(&flagKeygenCount, "count", "n", 1, "number of keypairs")
+	keygenCmd.Flags().StringVarP(&flagKeygenOutput, "output", "o", "", "sources file to write secret seeds")
+	keygenCmd.Flags().BoolVar(&flagKeygenForce, "force", false, "overwrite the existing file")
+	keygenCmd.Flags().BoolVar(&flagKeygenAnnotate, "annotate", false, "append the public address to each secret seed as comment")
+
+	rootCmd.AddCommand(keygenCmd)
+}
+
+// keygen writes the new secret seeds, one seed per line, and prints the
+// public addresses.
+func keygen(output string, count int, force, annotate bool) error {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(output, flag, 0600)
+	if os.IsExist(err) {
+		return fmt.Errorf("'%s' already exists; use --force to overwrite", output)
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for i := 0; i < count; i++ {
+		kp, err := keypair.Random()
+		if err != nil {
+			return err
+		}
+
+		line := kp.Seed()
+		if annotate {
+			line += " # " + kp.Address()
+		}
+		if _, err = fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+
+		fmt.Println(kp.Address())
+	}
+
+	return w.Flush()
+}
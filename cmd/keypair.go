@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/stellar/go/keypair"
+
+	"boscoin.io/sebak/lib/common"
+	"boscoin.io/sebak/lib/errors"
+	"boscoin.io/sebak/lib/network/httputils"
+)
+
+// KeypairResponse is the response of `POST /keypair`; Seed is the secret seed
+// of the new account.
+type KeypairResponse struct {
+	Address string        `json:"address"`
+	Seed    string        `json:"seed"`
+	Balance common.Amount `json:"balance"`
+	ID      string        `json:"id"`
+	State   RequestState  `json:"state"`
+	Hash    string        `json:"hash,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// keypairHandler generates new keypair and creates the account of it. It is
+// only for testnet; the secret seed is sent to the client.
+func (h *Handler) keypairHandler(w http.ResponseWriter, r *http.Request) {
+	setAccessControlHeaders(w)
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	cn, ok := w.(http.CloseNotifier)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	key, limit, err := h.authorize(r)
+	if err != nil {
+		countRequest(outcomeUnauthorized)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	balance := common.BaseReserve
+	if balanceString := r.URL.Query().Get("balance"); len(balanceString) > 0 {
+		if balance, err = common.AmountFromString(balanceString); err != nil {
+			countRequest(outcomeInvalid)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if balance < common.BaseReserve {
+		countRequest(outcomeUnderflow)
+		httputils.WriteJSONError(w, errors.OperationAmountUnderflow)
+		return
+	} else if balance > limit {
+		countRequest(outcomeOverflow)
+		httputils.WriteJSONError(w, errors.OperationAmountOverflow)
+		return
+	}
+
+	var timeout time.Duration
+	if timeout, err = parseTimeout(r); err != nil {
+		countRequest(outcomeInvalid)
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	// the address is not known to the client yet, so the solution is made
+	// with empty address.
+	if h.pow != nil && key == nil {
+		if err = h.pow.VerifyRequest(r, ""); err != nil {
+			countRequest(outcomePowFailed)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	var full *keypair.Full
+	if full, err = keypair.Random(); err != nil {
+		countRequest(outcomeFailed)
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	// the new address has no quota; only the budget of key is reserved.
+	options := RequestOptions{
+		Reserve: func(_ string, amount common.Amount) ([]string, error) {
+			return h.reserveBudget(key, amount)
+		},
+	}
+
+	var ra ReadyAccount
+	if ra, err = h.am.CreateAccount(full.Address(), balance, options); err == errIntakePaused || err == errShuttingDown {
+		countRequest(outcomePaused)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if e, ok := err.(*ReserveError); ok {
+		countRequest(outcomeQuotaExceeded)
+		httputils.WriteJSONError(w, e.Err)
+		return
+	} else if err != nil {
+		countRequest(outcomeFailed)
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	log.Debug("checking new keypair account", "address", full.Address(), "id", ra.ID)
+
+	response := KeypairResponse{
+		Address: full.Address(),
+		Seed:    full.Seed(),
+		Balance: balance,
+		ID:      ra.ID,
+		State:   RequestQueued,
+	}
+
+	statusCode := http.StatusCreated
+
+	var entry *RequestEntry
+	if entry, err = h.waitRequest(cn.CloseNotify(), ra.ID, timeout); err != nil {
+		// the seed is still returned, the account may be created later.
+		countWaitError(err)
+		w.Header().Set("Location", "/jobs/"+ra.ID)
+		statusCode = http.StatusOK
+		response.Error = err.Error()
+		entry, _ = h.am.Request(ra.ID)
+	} else {
+		countRequest(outcomeCreated)
+	}
+	if entry != nil {
+		response.State = entry.State
+		response.Hash = entry.Hash
+	}
+
+	body, err := common.JSONMarshalIndent(response)
+	if err != nil {
+		log.Debug("failed to serialize keypair", "error", err)
+		httputils.WriteJSONError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(statusCode)
+	w.Write(append(body, []byte("\n")...))
+}
@@ -0,0 +1,342 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"boscoin.io/sebak/lib/common"
+)
+
+const (
+	keystoreVersion int    = 1
+	keystoreKDF     string = "scrypt"
+	keystoreCipher  string = "aes-256-gcm"
+
+	// the bounds of the scrypt parameters of keystore file; the forged file
+	// must not make scrypt use the unbounded memory or time. scrypt uses
+	// 128 * N * R bytes.
+	maxKeystoreKDFN      int = 1 << 20
+	maxKeystoreKDFMemory int = 1 << 30
+	maxKeystoreKDFP      int = 16
+	minKeystoreSaltLen   int = 16
+)
+
+var (
+	importKeysCmd *cobra.Command
+
+	flagKeystore               string = common.GetENVValue("SEBAK_KEYSTORE", "")
+	flagKeystorePassphraseFile string = common.GetENVValue("SEBAK_KEYSTORE_PASSPHRASE_FILE", "")
+	flagImportKeysForce        bool
+
+	keystoreSourceSeeds []string
+)
+
+// defaultKeystoreKDFParams follows the recommended parameters of scrypt for
+// interactive logins.
+var defaultKeystoreKDFParams = KeystoreKDFParams{N: 1 << 15, R: 8, P: 1, KeyLen: 32}
+
+func init() {
+	importKeysCmd = &cobra.Command{
+		Use:   "import-keys",
+		Short: "convert --sources file and master secret seed to encrypted keystore",
+		Args:  cobra.ExactArgs(0),
+		Run: func(c *cobra.Command, args []string) {
+			if len(flagKeystore) < 1 {
+				printFlagsError(c, "--keystore", errors.New("must be given"))
+			}
+			if len(flagSources) < 1 {
+				printFlagsError(c, "--sources", errors.New("must be given"))
+			}
+
+			ks := &Keystore{}
+			if len(flagSecretSeed) > 0 {
+				if _, err := parseSourceSeed(flagSecretSeed); err != nil {
+					printFlagsError(c, "--secret-seed", err)
+				}
+				ks.Master = flagSecretSeed
+			}
+
+			accounts, err := readSourcesFile(flagSources)
+			if err != nil {
+				printFlagsError(c, "--sources", err)
+			}
+			for _, account := range accounts {
+				ks.Sources = append(ks.Sources, account.KP.Seed())
+			}
+			sort.Strings(ks.Sources)
+
+			passphrase, err := readKeystorePassphrase(flagKeystorePassphraseFile, true)
+			if err != nil {
+				printFlagsError(c, "--keystore-passphrase-file", err)
+			}
+
+			if err = writeKeystore(flagKeystore, ks, passphrase, flagImportKeysForce); err != nil {
+				printFlagsError(c, "--keystore", err)
+			}
+
+			fmt.Printf("imported master=%v sources=%d into '%s'\n", len(ks.Master) > 0, len(ks.Sources), flagKeystore)
+		},
+	}
+
+	importKeysCmd.Flags().StringVar(&flagKeystore, "keystore", flagKeystore, "keystore file to write")
+	importKeysCmd.Flags().StringVar(&flagKeystorePassphraseFile, "keystore-passphrase-file", flagKeystorePassphraseFile, "file of keystore passphrase; without it, SEBAK_KEYSTORE_PASSPHRASE or prompt")
+	importKeysCmd.Flags().StringVar(&flagSources, "sources", flagSources, "source account list file")
+	importKeysCmd.Flags().StringVar(&flagSecretSeed, "secret-seed", flagSecretSeed, "secret seed of master account")
+	importKeysCmd.Flags().BoolVar(&flagImportKeysForce, "force", false, "overwrite the existing keystore")
+
+	rootCmd.AddCommand(importKeysCmd)
+}
+
+// Keystore is the secret seeds of master and source accounts.
+type Keystore struct {
+	Master  string   `json:"master,omitempty"`
+	Sources []string `json:"sources"`
+}
+
+type KeystoreKDFParams struct {
+	N      int    `json:"n"`
+	R      int    `json:"r"`
+	P      int    `json:"p"`
+	KeyLen int    `json:"keylen"`
+	Salt   []byte `json:"salt"`
+}
+
+// KeystoreFile is the encrypted Keystore; the key of AES-GCM is derived from
+// the passphrase by scrypt.
+type KeystoreFile struct {
+	Version    int               `json:"version"`
+	KDF        string            `json:"kdf"`
+	KDFParams  KeystoreKDFParams `json:"kdfparams"`
+	Cipher     string            `json:"cipher"`
+	Nonce      []byte            `json:"nonce"`
+	Ciphertext []byte            `json:"ciphertext"`
+}
+
+// Validate checks the parameters are in the bounds; the key of AES-256 must
+// be 32 bytes.
+func (p KeystoreKDFParams) Validate() error {
+	switch {
+	case p.N < 2 || p.N > maxKeystoreKDFN || p.N&(p.N-1) != 0:
+		return fmt.Errorf("invalid kdf n=%d; must be power of 2 and not over %d", p.N, maxKeystoreKDFN)
+	case p.R < 1 || p.R > maxKeystoreKDFMemory/(128*p.N):
+		return fmt.Errorf("invalid kdf r=%d; memory of n and r must not be over %d bytes", p.R, maxKeystoreKDFMemory)
+	case p.P < 1 || p.P > maxKeystoreKDFP:
+		return fmt.Errorf("invalid kdf p=%d; must be between 1 and %d", p.P, maxKeystoreKDFP)
+	case p.KeyLen != 32:
+		return fmt.Errorf("invalid kdf keylen=%d; must be 32", p.KeyLen)
+	case len(p.Salt) < minKeystoreSaltLen:
+		return fmt.Errorf("invalid kdf salt; must be at least %d bytes", minKeystoreSaltLen)
+	}
+
+	return nil
+}
+
+func newKeystoreGCM(passphrase []byte, params KeystoreKDFParams) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, params.Salt, params.N, params.R, params.P, params.KeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func encryptKeystore(ks *Keystore, passphrase []byte) (*KeystoreFile, error) {
+	plaintext, err := json.Marshal(ks)
+	if err != nil {
+		return nil, err
+	}
+
+	params := defaultKeystoreKDFParams
+	params.Salt = make([]byte, 32)
+	if _, err = rand.Read(params.Salt); err != nil {
+		return nil, err
+	}
+
+	gcm, err := newKeystoreGCM(passphrase, params)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return &KeystoreFile{
+		Version:    keystoreVersion,
+		KDF:        keystoreKDF,
+		KDFParams:  params,
+		Cipher:     keystoreCipher,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, nil),
+	}, nil
+}
+
+func decryptKeystore(f *KeystoreFile, passphrase []byte) (*Keystore, error) {
+	if f.Version != keystoreVersion || f.KDF != keystoreKDF || f.Cipher != keystoreCipher {
+		return nil, fmt.Errorf(
+			"unsupported keystore; version=%d kdf=%s cipher=%s",
+			f.Version, f.KDF, f.Cipher,
+		)
+	}
+	if err := f.KDFParams.Validate(); err != nil {
+		return nil, err
+	}
+
+	gcm, err := newKeystoreGCM(passphrase, f.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+
+	plaintext, err := gcm.Open(nil, f.Nonce, f.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt keystore; wrong passphrase?")
+	}
+
+	var ks Keystore
+	if err = json.Unmarshal(plaintext, &ks); err != nil {
+		return nil, err
+	}
+
+	return &ks, nil
+}
+
+func readKeystore(path string, passphrase []byte) (*Keystore, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f KeystoreFile
+	if err = json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+
+	return decryptKeystore(&f, passphrase)
+}
+
+func writeKeystore(path string, ks *Keystore, passphrase []byte, force bool) error {
+	ksf, err := encryptKeystore(ks, passphrase)
+	if err != nil {
+		return err
+	}
+
+	b, err := common.JSONMarshalIndent(ksf)
+	if err != nil {
+		return err
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(path, flag, 0600)
+	if os.IsExist(err) {
+		return fmt.Errorf("'%s' already exists; use --force to overwrite", path)
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// readKeystorePassphrase reads the passphrase from the file,
+// `SEBAK_KEYSTORE_PASSPHRASE` or the prompt in order. With confirm, the
+// prompt asks the passphrase twice.
+func readKeystorePassphrase(path string, confirm bool) ([]byte, error) {
+	if len(path) > 0 {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		b = bytes.TrimRight(b, "\r\n")
+		if len(b) < 1 {
+			return nil, fmt.Errorf("empty passphrase in '%s'", path)
+		}
+
+		return b, nil
+	}
+
+	if s, found := os.LookupEnv("SEBAK_KEYSTORE_PASSPHRASE"); found && len(s) > 0 {
+		return []byte(s), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, errors.New("passphrase must be given by file or SEBAK_KEYSTORE_PASSPHRASE")
+	}
+
+	fmt.Fprint(os.Stderr, "keystore passphrase: ")
+	passphrase, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) < 1 {
+		return nil, errors.New("empty passphrase")
+	}
+
+	if confirm {
+		fmt.Fprint(os.Stderr, "confirm passphrase: ")
+		again, err := terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(passphrase, again) {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+
+	return passphrase, nil
+}
+
+// unlockKeystore sets the master secret seed and the source seeds from
+// `--keystore`.
+func unlockKeystore(c *cobra.Command) {
+	passphrase, err := readKeystorePassphrase(flagKeystorePassphraseFile, false)
+	if err != nil {
+		printFlagsError(c, "--keystore-passphrase-file", err)
+	}
+
+	ks, err := readKeystore(flagKeystore, passphrase)
+	if err != nil {
+		printFlagsError(c, "--keystore", err)
+	}
+
+	if len(ks.Master) > 0 {
+		if len(strings.TrimSpace(flagSecretSeed)) > 0 {
+			printFlagsError(c, "--secret-seed", errors.New("must not be given with --keystore, which has master"))
+		}
+		flagSecretSeed = ks.Master
+	}
+
+	keystoreSourceSeeds = ks.Sources
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	ks := &Keystore{Master: "master-seed", Sources: []string{"source-seed-0", "source-seed-1"}}
+	passphrase := []byte("passphrase")
+
+	cases := []struct {
+		name       string
+		passphrase []byte
+		modify     func(f *KeystoreFile)
+		err        bool
+	}{
+		{name: "valid", passphrase: passphrase},
+		{name: "wrong passphrase", passphrase: []byte("wrong"), err: true},
+		{name: "unknown version", passphrase: passphrase, modify: func(f *KeystoreFile) { f.Version = 2 }, err: true},
+		{name: "n not power of 2", passphrase: passphrase, modify: func(f *KeystoreFile) { f.KDFParams.N = 1<<15 + 1 }, err: true},
+		{name: "n too large", passphrase: passphrase, modify: func(f *KeystoreFile) { f.KDFParams.N = maxKeystoreKDFN << 1 }, err: true},
+		{name: "r too large", passphrase: passphrase, modify: func(f *KeystoreFile) { f.KDFParams.R = 1 << 30 }, err: true},
+		{name: "p too large", passphrase: passphrase, modify: func(f *KeystoreFile) { f.KDFParams.P = maxKeystoreKDFP + 1 }, err: true},
+		{name: "short keylen", passphrase: passphrase, modify: func(f *KeystoreFile) { f.KDFParams.KeyLen = 16 }, err: true},
+		{name: "short salt", passphrase: passphrase, modify: func(f *KeystoreFile) { f.KDFParams.Salt = f.KDFParams.Salt[:8] }, err: true},
+		{name: "tampered ciphertext", passphrase: passphrase, modify: func(f *KeystoreFile) { f.Ciphertext[0] ^= 0xff }, err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, err := encryptKeystore(ks, passphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.modify != nil {
+				c.modify(f)
+			}
+
+			decrypted, err := decryptKeystore(f, c.passphrase)
+			if c.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decrypted, ks) {
+				t.Errorf("expected %v; got %v", ks, decrypted)
+			}
+		})
+	}
+}
+
+func TestKeystoreFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "angelbot-keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keystore")
+	ks := &Keystore{Sources: []string{"source-seed-0"}}
+	passphrase := []byte("passphrase")
+
+	if err = writeKeystore(path, ks, passphrase, false); err != nil {
+		t.Fatal(err)
+	}
+	if err = writeKeystore(path, ks, passphrase, false); err == nil {
+		t.Error("existing keystore is overwritten without force")
+	}
+	if err = writeKeystore(path, ks, passphrase, true); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600; got %v", info.Mode().Perm())
+	}
+
+	read, err := readKeystore(path, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, ks) {
+		t.Errorf("expected %v; got %v", ks, read)
+	}
+}
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace string = "angelbot"
+
+const (
+	outcomeCreated       string = "created"
+	outcomePaid          string = "paid"
+	outcomeAlreadyExists string = "already-exists"
+	outcomeNotFound      string = "not-found"
+	outcomeUnderflow     string = "underflow"
+	outcomeOverflow      string = "overflow"
+	outcomeQuotaExceeded string = "quota-exceeded"
+	outcomeInvalid       string = "invalid"
+	outcomeAccepted      string = "accepted"
+	outcomeTimeout       string = "timeout"
+	outcomeFailed        string = "failed"
+	outcomeRateLimited   string = "rate-limited"
+	outcomePaused        string = "paused"
+	outcomePowFailed     string = "pow-failed"
+	outcomeUnauthorized  string = "unauthorized"
+	outcomeConflict      string = "conflict"
+	outcomeClosed        string = "closed"
+)
+
+var (
+	metricRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_total",
+			Help:      "Number of requests by outcome.",
+		},
+		[]string{"outcome"},
+	)
+	metricDisbursed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "disbursed_gon_total",
+			Help:      "Amount of GON sent to the requested accounts.",
+		},
+	)
+	metricTransactions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "transactions_total",
+			Help:      "Number of transactions by source and status, {submitted, confirmed, rejected, expired}.",
+		},
+		[]string{"source", "status"},
+	)
+	metricSourceBalance = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "source_balance_gon",
+			Help:      "Last known balance of source account.",
+		},
+		[]string{"source"},
+	)
+	metricUpstreamLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_request_duration_seconds",
+			Help:      "Latency of the requests to SEBAK node.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"call"},
+	)
+	metricKeyRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "api_key_requests_total",
+			Help:      "Number of requests by api key.",
+		},
+		[]string{"key"},
+	)
+	metricKeyRequested = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "api_key_requested_gon_total",
+			Help:      "Amount of GON requested by api key.",
+		},
+		[]string{"key"},
+	)
+	metricConfirmationLatency = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_confirmation_seconds",
+			Help:      "Time from the request to the confirmation of transaction.",
+			Buckets:   []float64{1, 3, 5, 10, 20, 30, 60, 120, 300, 600},
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		metricRequests,
+		metricDisbursed,
+		metricTransactions,
+		metricSourceBalance,
+		metricUpstreamLatency,
+		metricConfirmationLatency,
+		metricKeyRequests,
+		metricKeyRequested,
+	)
+}
+
+// registerManagerMetrics registers the gauges which are read from the
+// AccountManager.
+func registerManagerMetrics(am *AccountManager) {
+	prometheus.MustRegister(
+		prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace: metricsNamespace,
+				Name:      "pool_length",
+				Help:      "Number of requests waiting in the pool.",
+			},
+			func() float64 {
+				am.RLock()
+				defer am.RUnlock()
+
+				return float64(am.pool.Len())
+			},
+		),
+		prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace: metricsNamespace,
+				Name:      "unused_sources",
+				Help:      "Number of sources which are not sending transaction.",
+			},
+			func() float64 {
+				am.RLock()
+				defer am.RUnlock()
+
+				// with maxInflight, the busy sources are also in unused.
+				var unused int
+				for _, account := range am.accounts {
+					if account.inflight < 1 {
+						unused++
+					}
+				}
+
+				return float64(unused)
+			},
+		),
+	)
+}
+
+func countRequest(outcome string) {
+	metricRequests.WithLabelValues(outcome).Inc()
+}
+
+func observeUpstream(call string, started time.Time) {
+	metricUpstreamLatency.WithLabelValues(call).Observe(time.Since(started).Seconds())
+}
+
+type rateLimitPassedKey struct{}
+
+// countRateLimited wraps the rate limit middleware to count the requests,
+// which are limited.
+func countRateLimited(middleware mux.MiddlewareFunc) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		limited := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if passed, ok := r.Context().Value(rateLimitPassedKey{}).(*bool); ok {
+				*passed = true
+			}
+			next.ServeHTTP(w, r)
+		}))
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var passed bool
+			limited.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rateLimitPassedKey{}, &passed)))
+			if !passed {
+				countRequest(outcomeRateLimited)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	powChallengeHeader string = "X-Angelbot-Challenge"
+	powSolutionHeader  string = "X-Angelbot-Solution"
+)
+
+var (
+	errPowRequired      = errors.New("proof-of-work is required; get the challenge from /challenge")
+	errPowInvalid       = errors.New("invalid challenge")
+	errPowExpired       = errors.New("challenge is expired")
+	errPowUsed          = errors.New("challenge is already used")
+	errPowWrongSolution = errors.New("solution does not satisfy the difficulty")
+)
+
+// PowOptions configures the proof-of-work gate.
+type PowOptions struct {
+	// Difficulty is the number of leading zero bits of the solution hash; 0
+	// disables the gate.
+	Difficulty int
+	// MaxDifficulty is the limit of the raised difficulty under high load.
+	MaxDifficulty int
+	// LoadStep raises the difficulty by 1 for every LoadStep requests in
+	// pool; 0 keeps the difficulty.
+	LoadStep int
+	// TTL is how long the challenge is valid.
+	TTL time.Duration
+}
+
+func (o PowOptions) Enabled() bool {
+	return o.Difficulty > 0
+}
+
+// PowChallenge is the response of `GET /challenge`. The client finds the
+// solution, which makes `sha256(<challenge>:<address>:<solution>)` start with
+// `difficulty` zero bits, and sends both by the headers.
+type PowChallenge struct {
+	Challenge  string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	Expires    time.Time `json:"expires"`
+}
+
+// PowGate issues the challenges signed by HMAC and verifies the solutions;
+// the solved challenges are kept until they are expired, so they can not be
+// used again.
+type PowGate struct {
+	sync.Mutex
+
+	options PowOptions
+	secret  []byte
+	am      *AccountManager
+	used    map[string]time.Time
+	pruned  time.Time
+}
+
+func NewPowGate(options PowOptions, am *AccountManager) (*PowGate, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	return &PowGate{
+		options: options,
+		secret:  secret,
+		am:      am,
+		used:    map[string]time.Time{},
+		pruned:  time.Now(),
+	}, nil
+}
+
+// Difficulty returns the current difficulty; it is raised by the length of
+// pool.
+func (g *PowGate) Difficulty() int {
+	difficulty := g.options.Difficulty
+	if g.options.LoadStep > 0 {
+		g.am.RLock()
+		difficulty += g.am.pool.Len() / g.options.LoadStep
+		g.am.RUnlock()
+	}
+	if g.options.MaxDifficulty > 0 && difficulty > g.options.MaxDifficulty {
+		difficulty = g.options.MaxDifficulty
+	}
+
+	return difficulty
+}
+
+func (g *PowGate) sign(payload string) string {
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write([]byte(payload))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Challenge makes the new challenge, `<nonce>.<expires>.<difficulty>.<hmac>`.
+func (g *PowGate) Challenge() (PowChallenge, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return PowChallenge{}, err
+	}
+
+	difficulty := g.Difficulty()
+	expires := time.Now().Add(g.options.TTL)
+
+	payload := fmt.Sprintf("%s.%d.%d", hex.EncodeToString(nonce), expires.Unix(), difficulty)
+
+	return PowChallenge{
+		Challenge:  payload + "." + g.sign(payload),
+		Difficulty: difficulty,
+		Expires:    expires,
+	}, nil
+}
+
+// Verify checks the challenge and solution for the address.
+func (g *PowGate) Verify(challenge, solution, address string) error {
+	if len(challenge) < 1 || len(solution) < 1 {
+		return errPowRequired
+	}
+
+	sp := strings.Split(challenge, ".")
+	if len(sp) != 4 {
+		return errPowInvalid
+	}
+
+	payload := strings.Join(sp[:3], ".")
+	if !hmac.Equal([]byte(g.sign(payload)), []byte(sp[3])) {
+		return errPowInvalid
+	}
+
+	expires, err := strconv.ParseInt(sp[1], 10, 64)
+	if err != nil {
+		return errPowInvalid
+	}
+	difficulty, err := strconv.Atoi(sp[2])
+	if err != nil {
+		return errPowInvalid
+	}
+
+	now := time.Now()
+	if now.Unix() > expires {
+		return errPowExpired
+	}
+
+	if powLeadingZeros(challenge, address, solution) < difficulty {
+		return errPowWrongSolution
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	if now.Sub(g.pruned) > g.options.TTL {
+		for c, e := range g.used {
+			if now.After(e) {
+				delete(g.used, c)
+			}
+		}
+		g.pruned = now
+	}
+
+	if _, found := g.used[sp[0]]; found {
+		return errPowUsed
+	}
+	g.used[sp[0]] = time.Unix(expires, 0)
+
+	return nil
+}
+
+// VerifyRequest verifies the challenge and solution headers of request.
+func (g *PowGate) VerifyRequest(r *http.Request, address string) error {
+	return g.Verify(r.Header.Get(powChallengeHeader), r.Header.Get(powSolutionHeader), address)
+}
+
+func powLeadingZeros(challenge, address, solution string) (n int) {
+	sum := sha256.Sum256([]byte(challenge + ":" + address + ":" + solution))
+	for _, b := range sum {
+		n += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+
+	return
+}
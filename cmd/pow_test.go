@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func solvePowChallenge(challenge PowChallenge, address string) string {
+	for i := 0; ; i++ {
+		solution := strconv.Itoa(i)
+		if powLeadingZeros(challenge.Challenge, address, solution) >= challenge.Difficulty {
+			return solution
+		}
+	}
+}
+
+func TestPowGateVerify(t *testing.T) {
+	address := "GABC"
+
+	cases := []struct {
+		name string
+		ttl  time.Duration
+		// prepare returns the challenge and solution to verify; it may use
+		// them before.
+		prepare func(t *testing.T, g *PowGate, c PowChallenge) (string, string)
+		err     error
+	}{
+		{
+			name: "valid",
+			ttl:  time.Minute,
+			prepare: func(t *testing.T, g *PowGate, c PowChallenge) (string, string) {
+				return c.Challenge, solvePowChallenge(c, address)
+			},
+		},
+		{
+			name: "empty",
+			ttl:  time.Minute,
+			prepare: func(t *testing.T, g *PowGate, c PowChallenge) (string, string) {
+				return c.Challenge, ""
+			},
+			err: errPowRequired,
+		},
+		{
+			name: "replay",
+			ttl:  time.Minute,
+			prepare: func(t *testing.T, g *PowGate, c PowChallenge) (string, string) {
+				solution := solvePowChallenge(c, address)
+				if err := g.Verify(c.Challenge, solution, address); err != nil {
+					t.Fatal(err)
+				}
+				return c.Challenge, solution
+			},
+			err: errPowUsed,
+		},
+		{
+			name: "expired",
+			ttl:  -2 * time.Second,
+			prepare: func(t *testing.T, g *PowGate, c PowChallenge) (string, string) {
+				return c.Challenge, solvePowChallenge(c, address)
+			},
+			err: errPowExpired,
+		},
+		{
+			name: "tampered difficulty",
+			ttl:  time.Minute,
+			prepare: func(t *testing.T, g *PowGate, c PowChallenge) (string, string) {
+				sp := strings.Split(c.Challenge, ".")
+				sp[2] = "0"
+				return strings.Join(sp, "."), "0"
+			},
+			err: errPowInvalid,
+		},
+		{
+			name: "other address",
+			ttl:  time.Minute,
+			prepare: func(t *testing.T, g *PowGate, c PowChallenge) (string, string) {
+				for i := 0; ; i++ {
+					solution := strconv.Itoa(i)
+					if powLeadingZeros(c.Challenge, "GOTHER", solution) >= c.Difficulty &&
+						powLeadingZeros(c.Challenge, address, solution) < c.Difficulty {
+						return c.Challenge, solution
+					}
+				}
+			},
+			err: errPowWrongSolution,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			g, err := NewPowGate(PowOptions{Difficulty: 8, TTL: c.ttl}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			challenge, err := g.Challenge()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ch, solution := c.prepare(t, g, challenge)
+			if err = g.Verify(ch, solution, address); err != c.err {
+				t.Errorf("expected %v; got %v", c.err, err)
+			}
+		})
+	}
+}
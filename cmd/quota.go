@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"boscoin.io/sebak/lib/common"
+)
+
+const quotaAddressPrefix string = "address-"
+
+// QuotaRule limits the amount, which one address can be funded.
+type QuotaRule struct {
+	// Window is the period of rolling window
+	Window time.Duration
+	// WindowAmount is the maximum amount in the rolling window; 0 is
+	// unlimited.
+	WindowAmount common.Amount
+	// Lifetime is the maximum amount in total; 0 is unlimited.
+	Lifetime common.Amount
+}
+
+func (r QuotaRule) Enabled() bool {
+	return (r.Window > 0 && r.WindowAmount > 0) || r.Lifetime > 0
+}
+
+// recorded returns true if the amount is recorded by Reserve.
+func (r QuotaRule) recorded() bool {
+	return r.Enabled() || r.Window > 0
+}
+
+type quotaGrant struct {
+	Time   time.Time     `json:"time"`
+	Amount common.Amount `json:"amount"`
+}
+
+type quotaUsage struct {
+	Lifetime common.Amount `json:"lifetime"`
+	Grants   []quotaGrant  `json:"grants"`
+}
+
+// QuotaStore keeps the funded amount by the key on disk, so the limits
+// survive restarts.
+type QuotaStore struct {
+	sync.Mutex
+
+	db *leveldb.DB
+}
+
+func OpenQuotaStore(path string) (*QuotaStore, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QuotaStore{db: db}, nil
+}
+
+func (q *QuotaStore) Close() error {
+	return q.db.Close()
+}
+
+func (q *QuotaStore) load(key string) (usage quotaUsage, err error) {
+	var b []byte
+	if b, err = q.db.Get([]byte(key), nil); err == leveldb.ErrNotFound {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(b, &usage)
+	return
+}
+
+func (q *QuotaStore) save(key string, usage quotaUsage) error {
+	b, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+
+	return q.db.Put([]byte(key), b, nil)
+}
+
+// Reserve records the amount for the key if it does not exceed the rule. The
+// rule with Window records the amount even though it is not limited.
+func (q *QuotaStore) Reserve(key string, rule QuotaRule, amount common.Amount) error {
+	if !rule.recorded() {
+		return nil
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	usage, err := q.load(key)
+	if err != nil {
+		return err
+	}
+
+	if rule.Lifetime > 0 && usage.Lifetime+amount > rule.Lifetime {
+		return fmt.Errorf("lifetime quota exceeded; funded=%s quota=%s", usage.Lifetime, rule.Lifetime)
+	}
+
+	now := time.Now()
+
+	// remove the expired grants from the rolling window
+	var grants []quotaGrant
+	var windowed common.Amount
+	for _, g := range usage.Grants {
+		if now.Sub(g.Time) >= rule.Window {
+			continue
+		}
+		grants = append(grants, g)
+		windowed += g.Amount
+	}
+
+	if rule.Window > 0 && rule.WindowAmount > 0 && windowed+amount > rule.WindowAmount {
+		return fmt.Errorf(
+			"quota exceeded; funded=%s quota=%s window=%s",
+			windowed, rule.WindowAmount, rule.Window,
+		)
+	}
+
+	if rule.Window > 0 {
+		grants = append(grants, quotaGrant{Time: now, Amount: amount})
+	}
+	usage.Grants = grants
+	usage.Lifetime += amount
+
+	return q.save(key, usage)
+}
+
+// Release gives back the amount reserved for the key, which is not funded;
+// the newest grants are reduced first.
+func (q *QuotaStore) Release(key string, amount common.Amount) error {
+	q.Lock()
+	defer q.Unlock()
+
+	usage, err := q.load(key)
+	if err != nil {
+		return err
+	}
+
+	if usage.Lifetime > amount {
+		usage.Lifetime -= amount
+	} else {
+		usage.Lifetime = 0
+	}
+
+	for i := len(usage.Grants) - 1; i >= 0 && amount > 0; i-- {
+		if usage.Grants[i].Amount > amount {
+			usage.Grants[i].Amount -= amount
+			break
+		}
+		amount -= usage.Grants[i].Amount
+		usage.Grants = append(usage.Grants[:i], usage.Grants[i+1:]...)
+	}
+
+	return q.save(key, usage)
+}
+
+// ReleaseKeys releases the amount from the every key; the errors are only
+// logged.
+func (q *QuotaStore) ReleaseKeys(keys []string, amount common.Amount) {
+	for _, key := range keys {
+		if err := q.Release(key, amount); err != nil {
+			log.Error("failed to release quota", "key", key, "error", err)
+		}
+	}
+}
+
+// Usage returns the amount recorded for the key in the window and in total.
+func (q *QuotaStore) Usage(key string, window time.Duration) (windowed, lifetime common.Amount, err error) {
+	q.Lock()
+	defer q.Unlock()
+
+	var usage quotaUsage
+	if usage, err = q.load(key); err != nil {
+		return
+	}
+
+	now := time.Now()
+	for _, g := range usage.Grants {
+		if now.Sub(g.Time) < window {
+			windowed += g.Amount
+		}
+	}
+	lifetime = usage.Lifetime
+
+	return
+}
+
+// ReserveAddress records the amount funded to the address; reserved is the
+// key to release it, if it is recorded.
+func (q *QuotaStore) ReserveAddress(address string, rule QuotaRule, amount common.Amount) (reserved []string, err error) {
+	if err = q.Reserve(quotaAddressPrefix+address, rule, amount); err != nil || !rule.recorded() {
+		return
+	}
+
+	return []string{quotaAddressPrefix + address}, nil
+}
+
+// parseQuotaWindow parses the quota format, `<amount>-<period>`, ex)
+// '100000000000-24h'.
+func parseQuotaWindow(s string) (amount common.Amount, window time.Duration, err error) {
+	i := strings.LastIndex(s, "-")
+	if i < 1 {
+		err = fmt.Errorf("invalid quota format: '%s'", s)
+		return
+	}
+
+	if amount, err = common.AmountFromString(s[:i]); err != nil {
+		return
+	}
+	if window, err = time.ParseDuration(s[i+1:]); err != nil {
+		return
+	}
+	if window <= 0 {
+		err = fmt.Errorf("invalid quota period: '%s'", s)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"boscoin.io/sebak/lib/common"
+)
+
+func TestQuotaStoreReserve(t *testing.T) {
+	window := 100 * time.Millisecond
+
+	cases := []struct {
+		name    string
+		rule    QuotaRule
+		amounts []common.Amount
+		// sleep is the wait before the last amount
+		sleep time.Duration
+		// failed is the index of the amount, which exceeds the rule; -1 is
+		// none.
+		failed int
+	}{
+		{name: "disabled", rule: QuotaRule{}, amounts: []common.Amount{100, 100}, failed: -1},
+		{name: "in window", rule: QuotaRule{Window: window, WindowAmount: 300}, amounts: []common.Amount{100, 200}, failed: -1},
+		{name: "exceed window", rule: QuotaRule{Window: window, WindowAmount: 300}, amounts: []common.Amount{100, 200, 1}, failed: 2},
+		{name: "window expired", rule: QuotaRule{Window: window, WindowAmount: 300}, amounts: []common.Amount{300, 300}, sleep: window, failed: -1},
+		{name: "in lifetime", rule: QuotaRule{Lifetime: 300}, amounts: []common.Amount{100, 200}, failed: -1},
+		{name: "exceed lifetime", rule: QuotaRule{Lifetime: 300}, amounts: []common.Amount{100, 201}, failed: 1},
+		{name: "lifetime after window", rule: QuotaRule{Window: window, WindowAmount: 300, Lifetime: 400}, amounts: []common.Amount{300, 300}, sleep: window, failed: 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, closeDB := openTestDB(t)
+			defer closeDB()
+			quota := &QuotaStore{db: db}
+
+			for i, amount := range c.amounts {
+				if i == len(c.amounts)-1 && c.sleep > 0 {
+					time.Sleep(c.sleep)
+				}
+
+				err := quota.Reserve("key", c.rule, amount)
+				if i == c.failed && err == nil {
+					t.Fatalf("%d: expected error", i)
+				} else if i != c.failed && err != nil {
+					t.Fatalf("%d: %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestQuotaStoreRelease(t *testing.T) {
+	rule := QuotaRule{Window: time.Hour, WindowAmount: 300, Lifetime: 500}
+
+	cases := []struct {
+		name     string
+		reserved []common.Amount
+		released common.Amount
+		windowed common.Amount
+		lifetime common.Amount
+	}{
+		{name: "newest grant", reserved: []common.Amount{100, 200}, released: 200, windowed: 100, lifetime: 100},
+		{name: "part of grant", reserved: []common.Amount{100, 200}, released: 50, windowed: 250, lifetime: 250},
+		{name: "over grants", reserved: []common.Amount{100, 200}, released: 250, windowed: 50, lifetime: 50},
+		{name: "over reserved", reserved: []common.Amount{100}, released: 200, windowed: 0, lifetime: 0},
+		{name: "nothing reserved", released: 100, windowed: 0, lifetime: 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, closeDB := openTestDB(t)
+			defer closeDB()
+			quota := &QuotaStore{db: db}
+
+			for _, amount := range c.reserved {
+				if err := quota.Reserve("key", rule, amount); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := quota.Release("key", c.released); err != nil {
+				t.Fatal(err)
+			}
+
+			windowed, lifetime, err := quota.Usage("key", rule.Window)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if windowed != c.windowed || lifetime != c.lifetime {
+				t.Errorf("expected windowed=%d lifetime=%d; got windowed=%d lifetime=%d", c.windowed, c.lifetime, windowed, lifetime)
+			}
+
+			// the released amount can be reserved again.
+			if err := quota.Reserve("key", rule, rule.WindowAmount-c.windowed); err != nil {
+				t.Errorf("failed to reserve released amount; %v", err)
+			}
+		})
+	}
+}
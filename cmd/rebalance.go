@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"time"
+
+	"boscoin.io/sebak/lib/common"
+	"boscoin.io/sebak/lib/transaction/operation"
+)
+
+const maxOperationsInTransaction int = 300
+
+// RebalanceOptions decides when and how much the source accounts are refilled
+// from the master account.
+type RebalanceOptions struct {
+	// Interval is the period to check the balance of sources; 0 disables
+	// rebalancing.
+	Interval time.Duration
+	// LowBalance is the low-water mark; the source which has lower balance
+	// than this will be refilled.
+	LowBalance common.Amount
+	// HighBalance is the high-water mark; the source will be refilled up to
+	// this. The new source account is also created with this balance.
+	HighBalance common.Amount
+	// MasterLowBalance is the balance of master account to alert.
+	MasterLowBalance common.Amount
+}
+
+var DefaultRebalanceOptions = RebalanceOptions{
+	Interval:         time.Minute,
+	LowBalance:       common.Amount(100000000000),
+	HighBalance:      common.Amount(1000000000000),
+	MasterLowBalance: common.Amount(10000000000000),
+}
+
+func (am *AccountManager) watchRebalance() {
+	defer am.workers.Done()
+
+	ticker := time.NewTicker(am.rebalance.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-am.stopped:
+			return
+		case <-ticker.C:
+			am.rebalanceSources()
+		}
+	}
+}
+
+// rebalanceSources refreshes the balance of sources and refills the sources,
+// which are under the low-water mark, by paying from the master account.
+func (am *AccountManager) rebalanceSources() {
+	am.RLock()
+	accounts := make([]*Account, 0, len(am.accounts))
+	for _, account := range am.accounts {
+		accounts = append(accounts, account)
+	}
+	am.RUnlock()
+
+	var ras []ReadyAccount
+	var total common.Amount
+	for _, account := range accounts {
+		ba, err := getAccount(am.client, account.KP.Address())
+		if err != nil {
+			log.Error("failed to get source account", "source", account.KP.Address(), "error", err)
+			continue
+		}
+
+		am.Lock()
+		account.setBalance(ba.Balance)
+		am.Unlock()
+
+		metricSourceBalance.WithLabelValues(account.KP.Address()).Set(float64(ba.Balance))
+
+		if ba.Balance >= am.rebalance.LowBalance {
+			continue
+		}
+
+		amount := am.rebalance.HighBalance - ba.Balance
+		ras = append(ras, ReadyAccount{
+			Type:    operation.TypePayment,
+			Address: account.KP.Address(),
+			Balance: amount,
+		})
+		total += amount
+	}
+
+	master, err := getAccount(am.client, am.kp.Address())
+	if err != nil {
+		log.Error("failed to get master account", "error", err)
+		return
+	}
+
+	if master.Balance < am.rebalance.MasterLowBalance {
+		log.Crit(
+			"master account is running low",
+			"master", am.kp.Address(),
+			"balance", master.Balance,
+			"low-balance", am.rebalance.MasterLowBalance,
+		)
+	}
+
+	if len(ras) < 1 {
+		return
+	}
+
+	log.Debug("trying to rebalance sources", "sources", len(ras), "amount", total)
+
+	// pay only as much as the master can afford
+	available := master.Balance
+	for i, ra := range ras {
+		needed := ra.Balance + common.BaseFee
+		if available < needed {
+			log.Crit(
+				"master account can not refill sources",
+				"master", am.kp.Address(),
+				"balance", master.Balance,
+				"refilled", i,
+				"sources", len(ras),
+			)
+			ras = ras[:i]
+			break
+		}
+		available -= needed
+	}
+
+	for s := 0; s < len(ras); s += maxOperationsInTransaction {
+		e := s + maxOperationsInTransaction
+		if e > len(ras) {
+			e = len(ras)
+		}
+
+		hash, err := am.sendFromMaster(ras[s:e], time.Second*60)
+		if err != nil {
+			log.Error("failed to rebalance sources", "error", err)
+			return
+		}
+
+		am.Lock()
+		for _, ra := range ras[s:e] {
+			if account, found := am.accounts[ra.Address]; found {
+				account.Balance += ra.Balance
+			}
+		}
+		am.Unlock()
+
+		log.Info("sources rebalanced", "sources", e-s, "transaction", hash)
+	}
+}
@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"container/list"
+	"errors"
+	"sort"
+	"time"
+)
+
+// SourcesLoader reads the source accounts again; the invalid seeds are
+// returned as invalid, not as err, so they do not stop reloading.
+type SourcesLoader func() (accounts map[string]*Account, invalid []error, err error)
+
+// SourcesReload is the result of ReloadSources.
+type SourcesReload struct {
+	Added   []string `json:"added"`
+	Retired []string `json:"retired"`
+	Failed  []string `json:"failed"`
+	Invalid []string `json:"invalid"`
+}
+
+var errNoSourcesLoader = errors.New("sources loader is not set")
+
+func (am *AccountManager) SetSourcesLoader(loader SourcesLoader) {
+	am.sourcesLoader = loader
+}
+
+// ReloadSources reads the sources by SourcesLoader. The new sources are
+// checked and created like at Start, and then used; the removed sources are
+// retired after their transaction is finished.
+func (am *AccountManager) ReloadSources() (*SourcesReload, error) {
+	if am.sourcesLoader == nil {
+		return nil, errNoSourcesLoader
+	}
+
+	am.reloadLock.Lock()
+	defer am.reloadLock.Unlock()
+
+	accounts, invalid, err := am.sourcesLoader()
+	if err != nil {
+		log.Error("failed to reload sources", "error", err)
+		return nil, err
+	}
+
+	result := &SourcesReload{
+		Added:   []string{},
+		Retired: []string{},
+		Failed:  []string{},
+		Invalid: []string{},
+	}
+	for _, e := range invalid {
+		log.Error("invalid source found", "error", e)
+		result.Invalid = append(result.Invalid, e.Error())
+	}
+
+	if len(accounts) < 1 {
+		err = errors.New("sources are empty; keep the current sources")
+		log.Error("failed to reload sources", "error", err)
+		return nil, err
+	}
+
+	newAccounts := map[string]*Account{}
+
+	am.Lock()
+	for address := range am.accounts {
+		if _, found := accounts[address]; found {
+			continue
+		}
+		am.retireSource(address)
+		result.Retired = append(result.Retired, address)
+	}
+	for address, account := range accounts {
+		if _, found := am.accounts[address]; found {
+			continue
+		}
+		if retiring, found := am.retiring[address]; found {
+			// it is still sending transaction, releaseSource will put it
+			// back to unused; with only the expired transactions, it is
+			// unused now.
+			delete(am.retiring, address)
+			am.accounts[address] = retiring
+			am.created[address] = true
+			if retiring.inflight < 1 {
+				am.unused.PushBack(address)
+			}
+			result.Added = append(result.Added, address)
+			continue
+		}
+		newAccounts[address] = account
+	}
+	am.Unlock()
+
+	// the retired sources do not send the next transaction, so their expired
+	// transactions are reconciled now.
+	if len(result.Retired) > 0 {
+		am.reconcileExpired()
+	}
+
+	nonAccount, unchecked := am.checkSources(newAccounts)
+
+	failed := map[string]bool{}
+	for address, err := range unchecked {
+		log.Error("failed to check source", "address", address, "error", err)
+		failed[address] = true
+	}
+	for _, batch := range am.createSources(nonAccount, am.rebalance.HighBalance, time.Second*60) {
+		for _, account := range batch.accounts {
+			if batch.err != nil {
+				failed[account.KP.Address()] = true
+				continue
+			}
+			am.refreshBalance(account)
+		}
+		if batch.err != nil {
+			log.Error("failed to create sources", "accounts", len(batch.accounts), "error", batch.err)
+		}
+	}
+
+	am.Lock()
+	for address, account := range newAccounts {
+		if failed[address] {
+			result.Failed = append(result.Failed, address)
+			continue
+		}
+
+		am.accounts[address] = account
+		am.created[address] = true
+		am.unused.PushBack(address)
+		result.Added = append(result.Added, address)
+	}
+	am.Unlock()
+
+	sort.Strings(result.Added)
+	sort.Strings(result.Retired)
+	sort.Strings(result.Failed)
+
+	log.Info(
+		"sources reloaded",
+		"added", len(result.Added),
+		"retired", len(result.Retired),
+		"failed", len(result.Failed),
+		"invalid", len(result.Invalid),
+	)
+
+	return result, nil
+}
+
+// retireSource removes the source; if the source is sending transaction or
+// has the expired transactions, it is kept in retiring until they are
+// finished by releaseSource and reconcileExpired. am.Lock() must be held.
+func (am *AccountManager) retireSource(address string) {
+	account := am.accounts[address]
+	delete(am.accounts, address)
+	delete(am.created, address)
+	delete(am.disabled, address)
+
+	if e := am.findUnused(address); e != nil {
+		am.unused.Remove(e)
+	}
+
+	am.retiring[address] = account
+	am.dropRetiring(address)
+}
+
+// dropRetiring drops the retiring source, which has no transaction in flight
+// and no expired transaction. am.Lock() must be held.
+func (am *AccountManager) dropRetiring(address string) {
+	account, found := am.retiring[address]
+	if !found || account.inflight > 0 {
+		return
+	}
+	for _, etx := range am.expired {
+		if etx.Source == address {
+			return
+		}
+	}
+
+	delete(am.retiring, address)
+	metricSourceBalance.DeleteLabelValues(address)
+	log.Info("source retired", "source", address)
+}
+
+// findUnused returns the element of the address in unused. am.Lock() must be
+// held.
+func (am *AccountManager) findUnused(address string) *list.Element {
+	for e := am.unused.Front(); e != nil; e = e.Next() {
+		if e.Value.(string) == address {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// releaseSource puts back the source to unused after one of it's
+// transactions is finished; the retiring source is dropped after the last
+// transaction, if it has no expired transaction.
+func (am *AccountManager) releaseSource(source *Account) {
+	am.Lock()
+	defer am.Unlock()
+
+	source.inflight--
+	if source.inflight < 1 && source.resequence {
+		source.sequenced = false
+		source.resequence = false
+	}
+
+	address := source.KP.Address()
+	if _, found := am.retiring[address]; found {
+		am.dropRetiring(address)
+		return
+	}
+
+	if _, found := am.accounts[address]; !found {
+		return
+	}
+
+	// the source under maxInflight is kept in unused.
+	if am.findUnused(address) != nil {
+		return
+	}
+
+	am.unused.PushBack(address)
+	log.Debug("unused back", "unused", am.unused.Len(), "accounts", len(am.accounts))
+}
@@ -0,0 +1,343 @@
+package cmd
+
+import (
+	"container/list"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stellar/go/keypair"
+
+	"boscoin.io/sebak/lib/common"
+)
+
+func TestReloadSources(t *testing.T) {
+	a := common.BaseReserve
+
+	cases := []struct {
+		name string
+		// inflight is the number of transactions in flight of the current
+		// sources, "a" and "b".
+		inflight map[string]int
+		// retiring is the current sources, which are already retired, but
+		// still sending transaction.
+		retiring []string
+		// expired is the source, which has the expired transaction; it is
+		// reconciled after reloading.
+		expired string
+		// loaded is the sources read by SourcesLoader; "new" is not in the
+		// current sources.
+		loaded []string
+		// missing is not in node; broken fails to be checked by network error.
+		missing string
+		broken  string
+		invalid bool
+		err     bool
+
+		added   []string
+		retired []string
+		failed  []string
+		// kept is the retired sources, which are kept until their
+		// transactions are finished and reconciled.
+		kept []string
+		// sources is the sources in use after every transaction in flight is
+		// finished.
+		sources []string
+	}{
+		{
+			name:    "add",
+			loaded:  []string{"a", "b", "new"},
+			added:   []string{"new"},
+			sources: []string{"a", "b", "new"},
+		},
+		{
+			name:    "retire idle",
+			loaded:  []string{"a"},
+			retired: []string{"b"},
+			sources: []string{"a"},
+		},
+		{
+			name:     "retire in flight",
+			inflight: map[string]int{"b": 1},
+			loaded:   []string{"a"},
+			retired:  []string{"b"},
+			kept:     []string{"b"},
+			sources:  []string{"a"},
+		},
+		{
+			name:    "retire with expired",
+			expired: "b",
+			loaded:  []string{"a"},
+			retired: []string{"b"},
+			kept:    []string{"b"},
+			sources: []string{"a"},
+		},
+		{
+			name:     "retire in flight with expired",
+			inflight: map[string]int{"b": 1},
+			expired:  "b",
+			loaded:   []string{"a"},
+			retired:  []string{"b"},
+			kept:     []string{"b"},
+			sources:  []string{"a"},
+		},
+		{
+			name:     "add retiring with expired",
+			retiring: []string{"b"},
+			expired:  "b",
+			loaded:   []string{"a", "b"},
+			added:    []string{"b"},
+			sources:  []string{"a", "b"},
+		},
+		{
+			name:     "add retiring",
+			inflight: map[string]int{"b": 1},
+			retiring: []string{"b"},
+			loaded:   []string{"a", "b"},
+			added:    []string{"b"},
+			sources:  []string{"a", "b"},
+		},
+		{
+			name:    "unchecked",
+			loaded:  []string{"a", "b", "new"},
+			broken:  "new",
+			failed:  []string{"new"},
+			sources: []string{"a", "b"},
+		},
+		{
+			name:    "not created",
+			loaded:  []string{"a", "b", "new"},
+			missing: "new",
+			failed:  []string{"new"},
+			sources: []string{"a", "b"},
+		},
+		{
+			name:    "invalid",
+			loaded:  []string{"a", "b"},
+			invalid: true,
+			sources: []string{"a", "b"},
+		},
+		{
+			name:    "empty",
+			invalid: true,
+			err:     true,
+			sources: []string{"a", "b"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			node, client, closeNode := newTestNode(t)
+			defer closeNode()
+
+			am, addresses := newTestAccountManager(t, 1, []testSource{{balance: 10 * a}, {balance: 10 * a}})
+			am.client = client
+			// the master is not in node, so the sources can not be created.
+			am.kp, _ = keypair.Random()
+
+			names := map[string]string{addresses[0]: "a", addresses[1]: "b"}
+			sources := map[string]*Account{"a": am.accounts[addresses[0]], "b": am.accounts[addresses[1]]}
+
+			kp, _ := keypair.Random()
+			names[kp.Address()] = "new"
+			sources["new"] = &Account{KP: kp}
+			if c.missing != "new" {
+				node.setAccount(kp.Address(), 5*a, 1)
+			}
+			if c.broken == "new" {
+				node.broken[kp.Address()] = true
+			}
+
+			for name, n := range c.inflight {
+				address := sources[name].KP.Address()
+				sources[name].inflight = n
+				am.unused.Remove(am.findUnused(address))
+			}
+			for _, name := range c.retiring {
+				address := sources[name].KP.Address()
+				delete(am.accounts, address)
+				delete(am.created, address)
+				if e := am.findUnused(address); e != nil {
+					am.unused.Remove(e)
+				}
+				am.retiring[address] = sources[name]
+			}
+
+			// the expired transaction is not decided until the test confirms
+			// it.
+			am.expired = map[string]*expiredTransaction{}
+			if len(c.expired) > 0 {
+				address := sources[c.expired].KP.Address()
+				node.setAccount(address, 10*a, 10)
+				am.expired["tx"] = &expiredTransaction{Hash: "tx", Source: address, SequenceID: 10, Expired: time.Now()}
+			}
+
+			am.SetSourcesLoader(func() (map[string]*Account, []error, error) {
+				loaded := map[string]*Account{}
+				for _, name := range c.loaded {
+					// the loader reads the sources again, so they are new
+					// instances.
+					loaded[sources[name].KP.Address()] = &Account{KP: sources[name].KP}
+				}
+
+				var invalid []error
+				if c.invalid {
+					invalid = append(invalid, errors.New("invalid seed"))
+				}
+				return loaded, invalid, nil
+			})
+
+			toNames := func(addresses []string) (l []string) {
+				for _, address := range addresses {
+					l = append(l, names[address])
+				}
+				sort.Strings(l)
+				return
+			}
+
+			result, err := am.ReloadSources()
+			if c.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if added := toNames(result.Added); !reflect.DeepEqual(added, c.added) {
+					t.Errorf("expected added %v; got %v", c.added, added)
+				}
+				if retired := toNames(result.Retired); !reflect.DeepEqual(retired, c.retired) {
+					t.Errorf("expected retired %v; got %v", c.retired, retired)
+				}
+				if failed := toNames(result.Failed); !reflect.DeepEqual(failed, c.failed) {
+					t.Errorf("expected failed %v; got %v", c.failed, failed)
+				}
+				if c.invalid != (len(result.Invalid) > 0) {
+					t.Errorf("expected invalid=%v; got %v", c.invalid, result.Invalid)
+				}
+			}
+
+			var kept []string
+			for address := range am.retiring {
+				kept = append(kept, address)
+			}
+			if kept := toNames(kept); !reflect.DeepEqual(kept, c.kept) {
+				t.Errorf("expected kept %v; got %v", c.kept, kept)
+			}
+
+			// the current source keeps the instance; the added one has the
+			// balance from node.
+			for address, account := range am.accounts {
+				if name := names[address]; name != "new" && account != sources[name] {
+					t.Errorf("source %s is replaced", name)
+				} else if name == "new" && account.Balance != 5*a {
+					t.Errorf("expected balance of new source %d; got %d", 5*a, account.Balance)
+				}
+			}
+
+			for name, n := range c.inflight {
+				for i := 0; i < n; i++ {
+					am.releaseSource(sources[name])
+				}
+			}
+			if len(c.expired) > 0 {
+				if _, found := am.retiring[sources[c.expired].KP.Address()]; found != (len(c.kept) > 0) {
+					t.Errorf("expected retiring=%v until expired transaction is reconciled", len(c.kept) > 0)
+				}
+
+				node.setStatus("tx", "confirmed")
+				am.reconcileExpired()
+			}
+
+			if len(am.retiring) > 0 {
+				t.Errorf("retiring sources are not dropped; %d", len(am.retiring))
+			}
+
+			var used, unused []string
+			for address := range am.accounts {
+				used = append(used, address)
+			}
+			for e := am.unused.Front(); e != nil; e = e.Next() {
+				unused = append(unused, e.Value.(string))
+			}
+			if used := toNames(used); !reflect.DeepEqual(used, c.sources) {
+				t.Errorf("expected sources %v; got %v", c.sources, used)
+			}
+			if unused := toNames(unused); !reflect.DeepEqual(unused, c.sources) {
+				t.Errorf("expected unused %v; got %v", c.sources, unused)
+			}
+		})
+	}
+}
+
+func TestReleaseSource(t *testing.T) {
+	cases := []struct {
+		name       string
+		inflight   int
+		resequence bool
+		retiring   bool
+		// sequenceID is the expected sequence id of the next transaction;
+		// the local one is 10 and the one of node is 7.
+		sequenceID uint64
+		unused     bool
+	}{
+		{name: "last transaction", inflight: 1, sequenceID: 10, unused: true},
+		{name: "last transaction resequenced", inflight: 1, resequence: true, sequenceID: 7, unused: true},
+		{name: "in flight resequenced", inflight: 2, resequence: true, sequenceID: 10, unused: true},
+		{name: "retiring last transaction", inflight: 1, retiring: true, sequenceID: 10},
+		{name: "retiring in flight", inflight: 2, retiring: true, sequenceID: 10},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			node, client, closeNode := newTestNode(t)
+			defer closeNode()
+
+			kp, _ := keypair.Random()
+			node.setAccount(kp.Address(), common.BaseReserve, 7)
+
+			source := &Account{
+				KP:         kp,
+				sequenceID: 10,
+				sequenced:  true,
+				resequence: c.resequence,
+				inflight:   c.inflight,
+			}
+			am := &AccountManager{
+				client:   client,
+				accounts: map[string]*Account{},
+				retiring: map[string]*Account{},
+				unused:   list.New(),
+			}
+			if c.retiring {
+				am.retiring[kp.Address()] = source
+			} else {
+				am.accounts[kp.Address()] = source
+			}
+
+			am.releaseSource(source)
+
+			if (am.findUnused(kp.Address()) != nil) != c.unused {
+				t.Errorf("expected unused=%v", c.unused)
+			}
+			if _, found := am.retiring[kp.Address()]; c.retiring && found != (c.inflight > 1) {
+				t.Errorf("expected retiring=%v; got %v", c.inflight > 1, found)
+			}
+
+			// resequence is kept until the last transaction is finished.
+			if expected := c.resequence && c.inflight > 1; source.resequence != expected {
+				t.Errorf("expected resequence=%v; got %v", expected, source.resequence)
+			}
+			sequenceID, err := am.nextSequenceID(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sequenceID != c.sequenceID {
+				t.Errorf("expected sequence id %d; got %d", c.sequenceID, sequenceID)
+			}
+		})
+	}
+}
@@ -1,20 +1,24 @@
 package cmd
 
 import (
-	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	logging "github.com/inconshreveable/log15"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 	"github.com/stellar/go/keypair"
 	"github.com/ulule/limiter"
@@ -23,17 +27,18 @@ import (
 	cmdcommon "boscoin.io/sebak/cmd/sebak/common"
 	"boscoin.io/sebak/lib/common"
 	"boscoin.io/sebak/lib/network"
-	"boscoin.io/sebak/lib/node"
 )
 
 const (
 	defaultSEBAKEndpoint string      = "https://localhost:12345"
 	defaultBind          string      = "http://localhost:23456"
+	defaultDataDir       string      = "angelbot-data"
 	defaultHost          string      = "0.0.0.0"
 	defaultLogLevel      logging.Lvl = logging.LvlInfo
 )
 
 var (
+	flagConfig              string              = common.GetENVValue("SEBAK_CONFIG", "")
 	flagSecretSeed          string              = common.GetENVValue("SEBAK_SECRET_SEED", "")
 	flagNetworkID           string              = common.GetENVValue("SEBAK_NETWORK_ID", "")
 	flagLogLevel            string              = common.GetENVValue("SEBAK_LOG_LEVEL", defaultLogLevel.String())
@@ -44,8 +49,31 @@ var (
 	flagTLSCertFile         string              = common.GetENVValue("SEBAK_TLS_CERT", "sebak.crt")
 	flagTLSKeyFile          string              = common.GetENVValue("SEBAK_TLS_KEY", "sebak.key")
 	flagSources             string              = common.GetENVValue("SEBAK_SOURCES", "")
-	flagRateLimit           cmdcommon.ListFlags // "SEBAK_RATE_LIMIT"
+	flagRateLimit           cmdcommon.ListFlags // not read from environment
 	flagMaxBalance          string              = common.GetENVValue("SEBAK_MAX_BALANCE", defaultMaxBalance)
+	flagDataDir             string              = common.GetENVValue("SEBAK_DATA_DIR", defaultDataDir)
+	flagJournalRetention    string              = common.GetENVValue("SEBAK_JOURNAL_RETENTION", "168h")
+	flagRebalanceInterval   string              = common.GetENVValue("SEBAK_REBALANCE_INTERVAL", DefaultRebalanceOptions.Interval.String())
+	flagSourceLowBalance    string              = common.GetENVValue("SEBAK_SOURCE_LOW_BALANCE", DefaultRebalanceOptions.LowBalance.String())
+	flagSourceHighBalance   string              = common.GetENVValue("SEBAK_SOURCE_HIGH_BALANCE", DefaultRebalanceOptions.HighBalance.String())
+	flagMasterLowBalance    string              = common.GetENVValue("SEBAK_MASTER_LOW_BALANCE", DefaultRebalanceOptions.MasterLowBalance.String())
+	flagAddressQuota        string              = common.GetENVValue("SEBAK_ADDRESS_QUOTA", "")
+	flagAddressLifetime     string              = common.GetENVValue("SEBAK_ADDRESS_LIFETIME_QUOTA", "0")
+	flagAdminBind           string              = common.GetENVValue("SEBAK_ADMIN_BIND", "")
+	flagAdminToken          string              = common.GetENVValue("SEBAK_ADMIN_TOKEN", "")
+	flagShutdownTimeout     string              = common.GetENVValue("SEBAK_SHUTDOWN_TIMEOUT", "60s")
+	flagPowDifficulty       string              = common.GetENVValue("SEBAK_POW_DIFFICULTY", "0")
+	flagPowMaxDifficulty    string              = common.GetENVValue("SEBAK_POW_MAX_DIFFICULTY", "24")
+	flagPowLoadStep         string              = common.GetENVValue("SEBAK_POW_LOAD_STEP", "100")
+	flagPowTTL              string              = common.GetENVValue("SEBAK_POW_TTL", "5m")
+	flagAPIKeys             string              = common.GetENVValue("SEBAK_API_KEYS", "")
+	flagAnonymous           bool                = common.GetENVValue("SEBAK_ANONYMOUS", "1") == "1"
+	flagWebhookAllowHosts   cmdcommon.ListFlags // not read from environment
+	flagWebhookSecret       string              = common.GetENVValue("SEBAK_WEBHOOK_SECRET", "")
+	flagWebhookRetries      string              = common.GetENVValue("SEBAK_WEBHOOK_RETRIES", "5")
+	flagWebhookBackoff      string              = common.GetENVValue("SEBAK_WEBHOOK_BACKOFF", "1s")
+	flagEnableKeypair       bool                = common.GetENVValue("SEBAK_ENABLE_KEYPAIR", "0") == "1"
+	flagSourceMaxInflight   string              = common.GetENVValue("SEBAK_SOURCE_MAX_INFLIGHT", "1")
 )
 
 var (
@@ -54,6 +82,9 @@ var (
 	kp               *keypair.Full
 	sebakEndpoint    *common.Endpoint
 	bindURL          *url.URL
+	adminBindURL     *url.URL
+	shutdownTimeout  time.Duration
+	journalRetention time.Duration
 	logLevel         logging.Lvl
 	log              logging.Logger
 	sources          map[string]*Account = map[string]*Account{}
@@ -65,6 +96,12 @@ var (
 	}
 	defaultMaxBalance string = strconv.FormatUint(uint64(common.BaseReserve*100000), 10)
 	maxBalance        common.Amount
+	rebalanceOptions  RebalanceOptions
+	addressQuotaRule  QuotaRule
+	powOptions        PowOptions
+	apiKeys           *APIKeys
+	webhookOptions    WebhookOptions
+	sourceMaxInflight int
 )
 
 func init() {
@@ -80,17 +117,34 @@ func init() {
 		},
 	}
 
-	runCmd.Flags().StringVar(&flagSecretSeed, "secret-seed", flagSecretSeed, "secret seed of master account")
-	runCmd.Flags().StringVar(&flagNetworkID, "network-id", flagNetworkID, "network id")
-	runCmd.Flags().StringVar(&flagLogLevel, "log-level", flagLogLevel, "log level, {crit, error, warn, info, debug}")
-	runCmd.Flags().StringVar(&flagLogOutput, "log-output", flagLogOutput, "set log output file")
-	runCmd.Flags().BoolVar(&flagVerbose, "verbose", flagVerbose, "verbose")
-	runCmd.Flags().StringVar(&flagSEBAKEndpointString, "sebak-endpoint", flagSEBAKEndpointString, "sebak endpoint uri")
+	runCmd.Flags().StringVar(&flagConfig, "config", flagConfig, "config file, YAML or TOML; the command line flags and environment variables take precedence over it")
+	addClientFlags(runCmd)
 	runCmd.Flags().StringVar(&flagBind, "bind", flagBind, "bind address")
-	runCmd.Flags().StringVar(&flagTLSCertFile, "tls-cert", flagTLSCertFile, "tls certificate file")
-	runCmd.Flags().StringVar(&flagTLSKeyFile, "tls-key", flagTLSKeyFile, "tls key file")
 	runCmd.Flags().StringVar(&flagSources, "sources", flagSources, "source account list file")
-	runCmd.Flags().StringVar(&flagMaxBalance, "max-balance", flagMaxBalance, "maximum balance for new account")
+	runCmd.Flags().StringVar(&flagMaxBalance, "max-balance", flagMaxBalance, "maximum balance of new account; for payment, maximum balance of the account after payment")
+	runCmd.Flags().StringVar(&flagDataDir, "data-dir", flagDataDir, "directory to store the request journal")
+	runCmd.Flags().StringVar(&flagJournalRetention, "journal-retention", flagJournalRetention, "how long the finished requests are kept in journal, 0 keeps them forever")
+	runCmd.Flags().StringVar(&flagRebalanceInterval, "rebalance-interval", flagRebalanceInterval, "interval to check and refill the balance of sources, 0 disables rebalancing")
+	runCmd.Flags().StringVar(&flagSourceLowBalance, "source-low-balance", flagSourceLowBalance, "source is refilled when it's balance is under this")
+	runCmd.Flags().StringVar(&flagSourceHighBalance, "source-high-balance", flagSourceHighBalance, "source is refilled up to this balance")
+	runCmd.Flags().StringVar(&flagMasterLowBalance, "master-low-balance", flagMasterLowBalance, "alert when the balance of master account is under this")
+	runCmd.Flags().StringVar(&flagAddressQuota, "address-quota", flagAddressQuota, "maximum amount for one address in rolling window: <amount>-<period>, ex) '100000000000-24h'")
+	runCmd.Flags().StringVar(&flagAddressLifetime, "address-lifetime-quota", flagAddressLifetime, "maximum amount for one address in total, 0 is unlimited")
+	runCmd.Flags().StringVar(&flagAdminBind, "admin-bind", flagAdminBind, "bind address for admin api, ex) 'http://localhost:23457', 'unix:///tmp/angelbot.sock'")
+	runCmd.Flags().StringVar(&flagAdminToken, "admin-token", flagAdminToken, "bearer token for admin api")
+	runCmd.Flags().StringVar(&flagShutdownTimeout, "shutdown-timeout", flagShutdownTimeout, "maximum time to wait the pool and transactions at shutdown")
+	runCmd.Flags().StringVar(&flagPowDifficulty, "pow-difficulty", flagPowDifficulty, "leading zero bits of proof-of-work for creating account, 0 disables proof-of-work")
+	runCmd.Flags().StringVar(&flagPowMaxDifficulty, "pow-max-difficulty", flagPowMaxDifficulty, "maximum difficulty of proof-of-work under high load")
+	runCmd.Flags().StringVar(&flagPowLoadStep, "pow-load-step", flagPowLoadStep, "difficulty is raised by 1 for every this number of requests in pool, 0 keeps the difficulty")
+	runCmd.Flags().StringVar(&flagPowTTL, "pow-ttl", flagPowTTL, "expiration of proof-of-work challenge")
+	runCmd.Flags().StringVar(&flagAPIKeys, "api-keys", flagAPIKeys, "api keys file, YAML; each key has it's own rate limit, max balance and daily budget. 'POST /accounts' is enabled only with it")
+	runCmd.Flags().BoolVar(&flagAnonymous, "anonymous", flagAnonymous, "allow the requests without api key")
+	runCmd.Flags().Var(&flagWebhookAllowHosts, "webhook-allow-host", "allowed host of callback_url, ex) 'hooks.example.com' '*.example.com'; without it, callback_url is not allowed")
+	runCmd.Flags().StringVar(&flagWebhookSecret, "webhook-secret", flagWebhookSecret, "secret to sign the webhook payload")
+	runCmd.Flags().StringVar(&flagWebhookRetries, "webhook-retries", flagWebhookRetries, "maximum number of retries of webhook delivery")
+	runCmd.Flags().StringVar(&flagWebhookBackoff, "webhook-backoff", flagWebhookBackoff, "wait before the first retry of webhook; doubled for every retry up to 1m")
+	runCmd.Flags().StringVar(&flagSourceMaxInflight, "source-max-inflight", flagSourceMaxInflight, "maximum number of transactions of one source before they are confirmed; over 1 only if the node accepts the next sequence id in it's pool")
+	runCmd.Flags().BoolVar(&flagEnableKeypair, "enable-keypair", flagEnableKeypair, "enable 'POST /keypair', which returns the secret seed of new account; ONLY FOR TESTNET")
 	runCmd.Flags().Var(
 		&flagRateLimit,
 		"rate-limit",
@@ -103,142 +157,181 @@ func init() {
 func parseFlagsNode() {
 	var err error
 
-	if len(flagNetworkID) < 1 {
-		cmdcommon.PrintFlagsError(runCmd, "--network-id", errors.New("must be given"))
-	}
-	if len(flagSecretSeed) < 1 {
-		cmdcommon.PrintFlagsError(runCmd, "--secret-seed", errors.New("must be given"))
-	}
-
-	var parsedKP keypair.KP
-	parsedKP, err = keypair.Parse(flagSecretSeed)
-	if err != nil {
-		cmdcommon.PrintFlagsError(runCmd, "--secret-seed", err)
-	} else {
-		kp = parsedKP.(*keypair.Full)
+	if len(flagConfig) > 0 {
+		if err = loadConfig(runCmd, flagConfig); err != nil {
+			printFlagsError(runCmd, "--config", err)
+		}
 	}
 
 	if bindURL, err = url.Parse(flagBind); err != nil {
-		cmdcommon.PrintFlagsError(runCmd, "--bind", err)
+		printFlagsError(runCmd, "--bind", err)
 	}
 
-	if p, err := common.ParseEndpoint(flagSEBAKEndpointString); err != nil {
-		cmdcommon.PrintFlagsError(runCmd, "--endpoint", err)
-	} else {
-		sebakEndpoint = p
-		flagSEBAKEndpointString = sebakEndpoint.String()
+	if len(flagAdminBind) > 0 {
+		if adminBindURL, err = url.Parse(flagAdminBind); err != nil {
+			printFlagsError(runCmd, "--admin-bind", err)
+		}
+		switch adminBindURL.Scheme {
+		case "http", "https", "unix":
+		default:
+			printFlagsError(runCmd, "--admin-bind", fmt.Errorf("unknown scheme: '%s'", adminBindURL.Scheme))
+		}
+		if len(flagAdminToken) < 1 {
+			printFlagsError(runCmd, "--admin-token", errors.New("must be given with --admin-bind"))
+		}
 	}
 
-	if bindURL.Scheme == "https" {
+	if bindURL.Scheme == "https" || (adminBindURL != nil && adminBindURL.Scheme == "https") {
 		if _, err = os.Stat(flagTLSCertFile); os.IsNotExist(err) {
-			cmdcommon.PrintFlagsError(runCmd, "--tls-cert", err)
+			printFlagsError(runCmd, "--tls-cert", err)
 		}
 		if _, err = os.Stat(flagTLSKeyFile); os.IsNotExist(err) {
-			cmdcommon.PrintFlagsError(runCmd, "--tls-key", err)
+			printFlagsError(runCmd, "--tls-key", err)
 		}
 	}
 
 	if maxBalance, err = common.AmountFromString(flagMaxBalance); err != nil {
-		cmdcommon.PrintFlagsError(runCmd, "--max-balance", err)
+		printFlagsError(runCmd, "--max-balance", err)
 	}
 
-	if len(flagSources) < 1 {
-		cmdcommon.PrintFlagsError(runCmd, "--sources", errors.New("must be given"))
+	if rebalanceOptions.Interval, err = time.ParseDuration(flagRebalanceInterval); err != nil {
+		printFlagsError(runCmd, "--rebalance-interval", err)
 	}
-
-	sourcesF, err := os.Open(flagSources)
-	if err != nil {
-		cmdcommon.PrintFlagsError(runCmd, "--sources", err)
+	if rebalanceOptions.LowBalance, err = common.AmountFromString(flagSourceLowBalance); err != nil {
+		printFlagsError(runCmd, "--source-low-balance", err)
+	}
+	if rebalanceOptions.HighBalance, err = common.AmountFromString(flagSourceHighBalance); err != nil {
+		printFlagsError(runCmd, "--source-high-balance", err)
+	}
+	if rebalanceOptions.HighBalance <= rebalanceOptions.LowBalance {
+		printFlagsError(runCmd, "--source-high-balance", errors.New("must be greater than --source-low-balance"))
+	}
+	if rebalanceOptions.MasterLowBalance, err = common.AmountFromString(flagMasterLowBalance); err != nil {
+		printFlagsError(runCmd, "--master-low-balance", err)
 	}
 
-	{
-		scanner := bufio.NewScanner(sourcesF)
-		for scanner.Scan() {
-			s := scanner.Text()
-			sp := strings.Fields(s)
-			if len(sp) < 1 {
-				cmdcommon.PrintFlagsError(runCmd, "--sources", fmt.Errorf("invalid line found: '%s'", s))
-			}
-			kp, err := keypair.Parse(sp[0])
-			if err != nil {
-				cmdcommon.PrintFlagsError(runCmd, "--sources", err)
-			}
-			kpFull, ok := kp.(*keypair.Full)
-			if !ok {
-				cmdcommon.PrintFlagsError(runCmd, "--sources", fmt.Errorf("invalid secret seed found: '%s'", s))
-			}
+	if shutdownTimeout, err = time.ParseDuration(flagShutdownTimeout); err != nil {
+		printFlagsError(runCmd, "--shutdown-timeout", err)
+	}
 
-			sources[kpFull.Address()] = &Account{KP: kpFull}
-		}
-		if err := scanner.Err(); err != nil {
-			cmdcommon.PrintFlagsError(runCmd, "--sources", err)
-		}
+	if powOptions.Difficulty, err = strconv.Atoi(flagPowDifficulty); err != nil {
+		printFlagsError(runCmd, "--pow-difficulty", err)
+	} else if powOptions.Difficulty < 0 || powOptions.Difficulty > 256 {
+		printFlagsError(runCmd, "--pow-difficulty", errors.New("must be between 0 and 256"))
+	}
+	if powOptions.MaxDifficulty, err = strconv.Atoi(flagPowMaxDifficulty); err != nil {
+		printFlagsError(runCmd, "--pow-max-difficulty", err)
+	} else if powOptions.MaxDifficulty < powOptions.Difficulty {
+		printFlagsError(runCmd, "--pow-max-difficulty", errors.New("must not be less than --pow-difficulty"))
 	}
-	if len(sources) < 1 {
-		cmdcommon.PrintFlagsError(runCmd, "--sources", errors.New("sources are empty"))
+	if powOptions.LoadStep, err = strconv.Atoi(flagPowLoadStep); err != nil {
+		printFlagsError(runCmd, "--pow-load-step", err)
+	} else if powOptions.LoadStep < 0 {
+		printFlagsError(runCmd, "--pow-load-step", errors.New("must not be negative"))
+	}
+	if powOptions.TTL, err = time.ParseDuration(flagPowTTL); err != nil {
+		printFlagsError(runCmd, "--pow-ttl", err)
+	} else if powOptions.TTL <= 0 {
+		printFlagsError(runCmd, "--pow-ttl", errors.New("must be greater than 0"))
 	}
 
-	rateLimitRule, err = parseFlagRateLimit(flagRateLimit, defaultRateLimit)
-	if err != nil {
-		cmdcommon.PrintFlagsError(runCmd, "--rate-limit", err)
+	if len(flagAPIKeys) > 0 {
+		if apiKeys, err = LoadAPIKeys(flagAPIKeys); err != nil {
+			printFlagsError(runCmd, "--api-keys", err)
+		}
+	} else if !flagAnonymous {
+		printFlagsError(runCmd, "--anonymous", errors.New("--api-keys must be given to disable anonymous"))
 	}
 
-	queries := sebakEndpoint.Query()
-	queries.Add("TLSCertFile", flagTLSCertFile)
-	queries.Add("TLSKeyFile", flagTLSKeyFile)
-	queries.Add("IdleTimeout", "3s")
-	queries.Add("NodeName", node.MakeAlias(kp.Address()))
-	sebakEndpoint.RawQuery = queries.Encode()
+	if len(flagWebhookAllowHosts) > 0 {
+		webhookOptions.AllowHosts = flagWebhookAllowHosts
+		webhookOptions.Timeout = 10 * time.Second
+
+		if len(flagWebhookSecret) < 1 {
+			printFlagsError(runCmd, "--webhook-secret", errors.New("must be given with --webhook-allow-host"))
+		}
+		webhookOptions.Secret = flagWebhookSecret
 
-	if logLevel, err = logging.LvlFromString(flagLogLevel); err != nil {
-		cmdcommon.PrintFlagsError(runCmd, "--log-level", err)
+		if webhookOptions.Retries, err = strconv.Atoi(flagWebhookRetries); err != nil {
+			printFlagsError(runCmd, "--webhook-retries", err)
+		} else if webhookOptions.Retries < 0 {
+			printFlagsError(runCmd, "--webhook-retries", errors.New("must not be negative"))
+		}
+		if webhookOptions.Backoff, err = time.ParseDuration(flagWebhookBackoff); err != nil {
+			printFlagsError(runCmd, "--webhook-backoff", err)
+		}
 	}
 
-	logHandler := logging.StdoutHandler
+	if sourceMaxInflight, err = strconv.Atoi(flagSourceMaxInflight); err != nil {
+		printFlagsError(runCmd, "--source-max-inflight", err)
+	} else if sourceMaxInflight < 1 {
+		printFlagsError(runCmd, "--source-max-inflight", errors.New("must be greater than 0"))
+	}
 
-	if len(flagLogOutput) < 1 {
-		flagLogOutput = "<stdout>"
-	} else {
-		if logHandler, err = logging.FileHandler(flagLogOutput, logging.JsonFormat()); err != nil {
-			cmdcommon.PrintFlagsError(runCmd, "--log-output", err)
+	if len(flagAddressQuota) > 0 {
+		if addressQuotaRule.WindowAmount, addressQuotaRule.Window, err = parseQuotaWindow(flagAddressQuota); err != nil {
+			printFlagsError(runCmd, "--address-quota", err)
 		}
 	}
+	if addressQuotaRule.Lifetime, err = common.AmountFromString(flagAddressLifetime); err != nil {
+		printFlagsError(runCmd, "--address-lifetime-quota", err)
+	}
+
+	if len(flagDataDir) < 1 {
+		printFlagsError(runCmd, "--data-dir", errors.New("must be given"))
+	}
+	if flagDataDir, err = filepath.Abs(flagDataDir); err != nil {
+		printFlagsError(runCmd, "--data-dir", err)
+	}
+	if err = os.MkdirAll(flagDataDir, 0700); err != nil {
+		printFlagsError(runCmd, "--data-dir", err)
+	}
+	if journalRetention, err = time.ParseDuration(flagJournalRetention); err != nil {
+		printFlagsError(runCmd, "--journal-retention", err)
+	} else if journalRetention < 0 {
+		printFlagsError(runCmd, "--journal-retention", errors.New("must not be negative"))
+	}
+
+	rateLimitRule, err = parseFlagRateLimit(flagRateLimit, defaultRateLimit)
+	if err != nil {
+		printFlagsError(runCmd, "--rate-limit", err)
+	}
 
-	log = logging.New("module", "main")
-	log.SetHandler(logging.LvlFilterHandler(logLevel, logHandler))
-	network.SetLogging(logLevel, logHandler)
+	parseFlagsClient(runCmd)
+	loadSources(runCmd)
 
 	log.Info("Starting sebak angelbot")
 
 	// print flags
 	parsedFlags := []interface{}{}
+	parsedFlags = append(parsedFlags, "\n\tconfig", flagConfig)
 	parsedFlags = append(parsedFlags, "\n\tnetwork-id", flagNetworkID)
 	parsedFlags = append(parsedFlags, "\n\tsebak endpoint", flagSEBAKEndpointString)
 	parsedFlags = append(parsedFlags, "\n\tbind", flagBind)
+	parsedFlags = append(parsedFlags, "\n\tadmin-bind", flagAdminBind)
 	parsedFlags = append(parsedFlags, "\n\ttls-cert", flagTLSCertFile)
 	parsedFlags = append(parsedFlags, "\n\ttls-key", flagTLSKeyFile)
 	parsedFlags = append(parsedFlags, "\n\tlog-level", flagLogLevel)
 	parsedFlags = append(parsedFlags, "\n\tlog-output", flagLogOutput)
 	parsedFlags = append(parsedFlags, "\n\tsources", len(sources))
 	parsedFlags = append(parsedFlags, "\n\tmax-balance", maxBalance)
+	parsedFlags = append(parsedFlags, "\n\tdata-dir", flagDataDir)
+	parsedFlags = append(parsedFlags, "\n\tjournal-retention", journalRetention)
+	parsedFlags = append(parsedFlags, "\n\trebalance-interval", rebalanceOptions.Interval)
+	parsedFlags = append(parsedFlags, "\n\tsource-low-balance", rebalanceOptions.LowBalance)
+	parsedFlags = append(parsedFlags, "\n\tsource-high-balance", rebalanceOptions.HighBalance)
+	parsedFlags = append(parsedFlags, "\n\tmaster-low-balance", rebalanceOptions.MasterLowBalance)
+	parsedFlags = append(parsedFlags, "\n\tsource-max-inflight", sourceMaxInflight)
+	parsedFlags = append(parsedFlags, "\n\taddress-quota", flagAddressQuota)
+	parsedFlags = append(parsedFlags, "\n\taddress-lifetime-quota", addressQuotaRule.Lifetime)
+	parsedFlags = append(parsedFlags, "\n\tpow-difficulty", powOptions.Difficulty)
+	parsedFlags = append(parsedFlags, "\n\tpow-max-difficulty", powOptions.MaxDifficulty)
+	parsedFlags = append(parsedFlags, "\n\tapi-keys", flagAPIKeys)
+	parsedFlags = append(parsedFlags, "\n\tanonymous", flagAnonymous)
+	parsedFlags = append(parsedFlags, "\n\twebhook-allow-host", flagWebhookAllowHosts)
+	parsedFlags = append(parsedFlags, "\n\tenable-keypair", flagEnableKeypair)
 
 	log.Debug("parsed flags:", parsedFlags...)
-
-	// check node status
-	http2Client, _ := common.NewHTTP2Client(
-		3*time.Second,
-		3*time.Second,
-		false,
-	)
-	client := network.NewHTTP2NetworkClient(sebakEndpoint, http2Client)
-	if _, err := client.GetNodeInfo(); err != nil {
-		cmdcommon.PrintFlagsError(runCmd, "--sebak-endpoint", err)
-	}
-
-	if flagVerbose {
-		http2.VerboseLogs = true
-		verbose = true
-	}
 }
 
 func parseFlagRateLimit(l cmdcommon.ListFlags, defaultRate limiter.Rate) (rule common.RateLimitRule, err error) {
@@ -291,7 +384,33 @@ func parseFlagRateLimit(l cmdcommon.ListFlags, defaultRate limiter.Rate) (rule c
 }
 
 func run() {
-	am := NewAccountManager([]byte(flagNetworkID), kp, sebakEndpoint, sources)
+	journal, err := OpenJournal(filepath.Join(flagDataDir, "journal"))
+	if err != nil {
+		log.Crit("failed to open journal", "error", err)
+		return
+	}
+	defer journal.Close()
+
+	quota, err := OpenQuotaStore(filepath.Join(flagDataDir, "quota"))
+	if err != nil {
+		log.Crit("failed to open quota store", "error", err)
+		return
+	}
+	defer quota.Close()
+
+	am := NewAccountManager([]byte(flagNetworkID), kp, sebakEndpoint, sources, journal)
+	am.SetRebalanceOptions(rebalanceOptions)
+	am.SetMaxInflight(sourceMaxInflight)
+	am.SetJournalRetention(journalRetention)
+	am.SetQuota(quota)
+	am.SetSourcesLoader(reloadSources)
+
+	var webhooks *Webhooks
+	if webhookOptions.Enabled() {
+		webhooks = NewWebhooks(webhookOptions)
+		am.SetWebhooks(webhooks)
+	}
+	registerManagerMetrics(am)
 	am.Start()
 
 	server := &http.Server{Addr: bindURL.Host}
@@ -304,25 +423,148 @@ func run() {
 		kp:            kp,
 		sebakEndpoint: sebakEndpoint,
 		networkID:     []byte(flagNetworkID),
+		quota:         quota,
+		quotaRule:     addressQuotaRule,
+		anonymous:     flagAnonymous,
+		webhooks:      webhooks,
+	}
+	if powOptions.Enabled() {
+		if handler.pow, err = NewPowGate(powOptions, am); err != nil {
+			log.Crit("failed to make proof-of-work gate", "error", err)
+			shutdown(am)
+			return
+		}
 	}
-	router := mux.NewRouter()
 
-	router.Use(network.RateLimitMiddleware(log, rateLimitRule))
+	root := mux.NewRouter()
+	// the metrics are scraped without rate limit and api key.
+	root.Handle("/metrics", promhttp.Handler()).Methods("GET")
+
+	router := root.PathPrefix("/").Subrouter()
+
+	rateLimitMiddleware := countRateLimited(network.RateLimitMiddleware(log, rateLimitRule))
+	if apiKeys != nil {
+		rateLimitMiddleware = apiKeyMiddleware(apiKeys, rateLimitMiddleware)
+	}
+	router.Use(rateLimitMiddleware)
 
 	router.HandleFunc("/account/{address}", handler.accountHandler).Methods("POST", "GET", "OPTIONS")
+	router.HandleFunc("/account/{address}/events", handler.eventsHandler).Methods("GET", "OPTIONS")
+	if apiKeys != nil {
+		router.HandleFunc("/accounts", handler.bulkAccountsHandler).Methods("POST", "OPTIONS")
+	} else {
+		log.Info("'POST /accounts' is disabled without api keys")
+	}
+	router.HandleFunc("/payment/{address}", handler.paymentHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/jobs/{id}", handler.jobHandler).Methods("GET", "OPTIONS")
+	if handler.pow != nil {
+		router.HandleFunc("/challenge", handler.challengeHandler).Methods("GET", "OPTIONS")
+	}
+	if flagEnableKeypair {
+		log.Warn("'POST /keypair' is enabled; the secret seeds are sent to clients, use it only for testnet")
+		router.HandleFunc("/keypair", handler.keypairHandler).Methods("POST", "OPTIONS")
+	}
 	router.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
-	server.Handler = handlers.CombinedLoggingHandler(os.Stdout, router)
+	server.Handler = handlers.CombinedLoggingHandler(os.Stdout, root)
 
-	var err error
-	if bindURL.Scheme == "https" {
-		err = server.ListenAndServeTLS(flagTLSCertFile, flagTLSKeyFile)
-	} else {
-		err = server.ListenAndServe()
+	errChan := make(chan error, 2)
+
+	var adminServer *http.Server
+	if adminBindURL != nil {
+		if adminServer, err = runAdmin(am, quota, errChan); err != nil {
+			log.Crit("failed to listen admin", "error", err)
+			shutdown(am)
+			return
+		}
+	}
+
+	go func() {
+		if bindURL.Scheme == "https" {
+			errChan <- server.ListenAndServeTLS(flagTLSCertFile, flagTLSKeyFile)
+		} else {
+			errChan <- server.ListenAndServe()
+		}
+	}()
+
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for _ = range hupChan {
+			log.Info("got SIGHUP; reloading sources")
+			am.ReloadSources()
+		}
+	}()
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err = <-errChan:
+		log.Crit("something wrong", "error", err)
+	case sig := <-signalChan:
+		log.Info("got signal; shutting down", "signal", sig)
 	}
-	log.Crit("something wrong", "error", err)
+
+	shutdown(am, server, adminServer)
+
+	log.Info("sebak angelbot stopped")
 
 	return
 }
+
+// shutdown stops taking new requests and finishes the pool, while the
+// servers are shut down; the waiting clients are answered with the last
+// states of their requests after AccountManager is stopped. The journal can
+// be closed after shutdown returns.
+func shutdown(am *AccountManager, servers ...*http.Server) {
+	am.Close()
+
+	stopped := make(chan struct{})
+	go func() {
+		am.Stop(shutdownTimeout)
+		close(stopped)
+	}()
+
+	// the handlers wait until AccountManager is stopped.
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout+10*time.Second)
+	defer cancel()
+
+	for _, server := range servers {
+		if server == nil {
+			continue
+		}
+		if err := server.Shutdown(ctx); err != nil {
+			log.Error("failed to shutdown server", "error", err)
+		}
+	}
+
+	<-stopped
+}
+
+func runAdmin(am *AccountManager, quota *QuotaStore, errChan chan<- error) (*http.Server, error) {
+	admin := &AdminHandler{am: am, token: flagAdminToken, quota: quota, keys: apiKeys}
+
+	server := &http.Server{
+		Handler: handlers.CombinedLoggingHandler(os.Stdout, admin.Router()),
+	}
+
+	listener, err := listenAdmin(adminBindURL)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("admin api is ready", "bind", flagAdminBind)
+
+	go func() {
+		if adminBindURL.Scheme == "https" {
+			errChan <- server.ServeTLS(listener, flagTLSCertFile, flagTLSKeyFile)
+		} else {
+			errChan <- server.Serve(listener)
+		}
+	}()
+
+	return server, nil
+}
@@ -0,0 +1,320 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stellar/go/keypair"
+
+	"boscoin.io/sebak/lib/common"
+)
+
+var (
+	sourcesCmd   *cobra.Command
+	provisionCmd *cobra.Command
+
+	flagProvisionAmount  string = common.GetENVValue("SEBAK_PROVISION_AMOUNT", DefaultRebalanceOptions.HighBalance.String())
+	flagProvisionTimeout string = common.GetENVValue("SEBAK_PROVISION_TIMEOUT", "60s")
+)
+
+func init() {
+	sourcesCmd = &cobra.Command{
+		Use:   "sources",
+		Short: "manage source accounts",
+		Run: func(c *cobra.Command, args []string) {
+			if len(args) < 1 {
+				c.Usage()
+			}
+		},
+	}
+
+	provisionCmd = &cobra.Command{
+		Use:   "provision",
+		Short: "create the missing source accounts from master account",
+		Args:  cobra.ExactArgs(0),
+		Run: func(c *cobra.Command, args []string) {
+			if len(flagConfig) > 0 {
+				if err := loadConfig(c, flagConfig); err != nil {
+					printFlagsError(c, "--config", err)
+				}
+			}
+
+			amount, err := common.AmountFromString(flagProvisionAmount)
+			if err != nil {
+				printFlagsError(c, "--amount", err)
+			} else if amount < common.BaseReserve {
+				printFlagsError(c, "--amount", fmt.Errorf("must be greater than %s", common.BaseReserve))
+			}
+
+			timeout, err := time.ParseDuration(flagProvisionTimeout)
+			if err != nil {
+				printFlagsError(c, "--timeout", err)
+			}
+
+			parseFlagsClient(c)
+			loadSources(c)
+
+			am := NewAccountManager([]byte(flagNetworkID), kp, sebakEndpoint, sources, nil)
+			results := am.Provision(amount, timeout)
+
+			if !printProvisionResults(results) {
+				os.Exit(1)
+			}
+		},
+	}
+
+	provisionCmd.Flags().StringVar(&flagConfig, "config", flagConfig, "config file of run command, YAML or TOML")
+	addClientFlags(provisionCmd)
+	provisionCmd.Flags().StringVar(&flagSources, "sources", flagSources, "source account list file")
+	provisionCmd.Flags().StringVar(&flagProvisionAmount, "amount", flagProvisionAmount, "initial balance of the created source")
+	provisionCmd.Flags().StringVar(&flagProvisionTimeout, "timeout", flagProvisionTimeout, "maximum time to wait the confirmation of each transaction")
+
+	sourcesCmd.AddCommand(provisionCmd)
+	rootCmd.AddCommand(sourcesCmd)
+}
+
+// readSourcesFile reads the secret seeds from the sources file; each line
+// starts with secret seed and the rest of line is ignored.
+func readSourcesFile(path string) (map[string]*Account, error) {
+	accounts, invalid, err := scanSourcesFile(path)
+	if err != nil {
+		return nil, err
+	} else if len(invalid) > 0 {
+		return nil, invalid[0]
+	}
+
+	return accounts, nil
+}
+
+// scanSourcesFile is readSourcesFile, but the invalid lines are returned
+// separately instead of failing.
+func scanSourcesFile(path string) (accounts map[string]*Account, invalid []error, err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+
+	accounts = map[string]*Account{}
+
+	var n int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		n++
+		s := scanner.Text()
+		sp := strings.Fields(s)
+		if len(sp) < 1 {
+			invalid = append(invalid, fmt.Errorf("line %d: invalid line found: '%s'", n, s))
+			continue
+		}
+		kpFull, err := parseSourceSeed(sp[0])
+		if err != nil {
+			invalid = append(invalid, fmt.Errorf("line %d: %v", n, err))
+			continue
+		}
+
+		accounts[kpFull.Address()] = &Account{KP: kpFull}
+	}
+	err = scanner.Err()
+
+	return
+}
+
+func parseSourceSeed(seed string) (*keypair.Full, error) {
+	kp, err := keypair.Parse(seed)
+	if err != nil {
+		return nil, err
+	}
+	kpFull, ok := kp.(*keypair.Full)
+	if !ok {
+		return nil, fmt.Errorf("invalid secret seed found: '%s'", seed)
+	}
+
+	return kpFull, nil
+}
+
+// loadSources fills `sources` from `--sources` file, the source seeds of
+// config file and keystore.
+func loadSources(c *cobra.Command) {
+	if len(flagSources) < 1 && len(configSourceSeeds) < 1 && len(keystoreSourceSeeds) < 1 {
+		printFlagsError(c, "--sources", errors.New("must be given"))
+	}
+
+	if len(flagSources) > 0 {
+		accounts, err := readSourcesFile(flagSources)
+		if err != nil {
+			printFlagsError(c, "--sources", err)
+		}
+		for address, account := range accounts {
+			sources[address] = account
+		}
+	}
+
+	for _, seed := range configSourceSeeds {
+		kpFull, err := parseSourceSeed(seed)
+		if err != nil {
+			printFlagsError(c, "--config", fmt.Errorf("key '%s': %v", configSourceSeedsKey, err))
+		}
+
+		sources[kpFull.Address()] = &Account{KP: kpFull}
+	}
+
+	for _, seed := range keystoreSourceSeeds {
+		kpFull, err := parseSourceSeed(seed)
+		if err != nil {
+			printFlagsError(c, "--keystore", err)
+		}
+
+		sources[kpFull.Address()] = &Account{KP: kpFull}
+	}
+
+	if len(sources) < 1 {
+		printFlagsError(c, "--sources", errors.New("sources are empty"))
+	}
+}
+
+// reloadSources reads the sources again from `--sources` file, config file
+// and keystore; it is the SourcesLoader of `run`. The keystore is read again
+// only when the passphrase is given without prompt.
+func reloadSources() (accounts map[string]*Account, invalid []error, err error) {
+	accounts = map[string]*Account{}
+
+	if len(flagSources) > 0 {
+		if accounts, invalid, err = scanSourcesFile(flagSources); err != nil {
+			return
+		}
+	}
+
+	var seeds []string
+	if len(flagConfig) > 0 {
+		var m map[string]interface{}
+		if m, err = readConfig(flagConfig); err != nil {
+			return
+		}
+		if value, found := m[configSourceSeedsKey]; found {
+			if seeds, err = parseConfigSourceSeeds(value); err != nil {
+				return
+			}
+		}
+	}
+
+	if len(flagKeystore) > 0 {
+		ksSeeds := keystoreSourceSeeds
+		if len(flagKeystorePassphraseFile) > 0 || len(os.Getenv("SEBAK_KEYSTORE_PASSPHRASE")) > 0 {
+			var passphrase []byte
+			if passphrase, err = readKeystorePassphrase(flagKeystorePassphraseFile, false); err != nil {
+				return
+			}
+			var ks *Keystore
+			if ks, err = readKeystore(flagKeystore, passphrase); err != nil {
+				return
+			}
+			ksSeeds = ks.Sources
+		}
+		seeds = append(seeds, ksSeeds...)
+	}
+
+	for _, seed := range seeds {
+		kpFull, err := parseSourceSeed(seed)
+		if err != nil {
+			invalid = append(invalid, err)
+			continue
+		}
+
+		accounts[kpFull.Address()] = &Account{KP: kpFull}
+	}
+
+	return
+}
+
+// SourceProvision is the result of provisioning one source account.
+type SourceProvision struct {
+	Address string
+	Existed bool
+	Balance common.Amount
+	Hash    string
+	Error   error
+}
+
+// Provision creates the source accounts, which do not exist yet, with the
+// amount from master account and waits until they are confirmed.
+func (am *AccountManager) Provision(amount common.Amount, timeout time.Duration) []SourceProvision {
+	nonAccount, unchecked := am.checkSources(am.accounts)
+
+	missing := map[string]bool{}
+	for _, account := range nonAccount {
+		missing[account.KP.Address()] = true
+	}
+
+	results := map[string]*SourceProvision{}
+	for address, account := range am.accounts {
+		results[address] = &SourceProvision{
+			Address: address,
+			Existed: !missing[address],
+			Balance: account.Balance,
+			Error:   unchecked[address],
+		}
+	}
+
+	for _, batch := range am.createSources(nonAccount, amount, timeout) {
+		for _, account := range batch.accounts {
+			r := results[account.KP.Address()]
+			r.Hash = batch.hash
+			r.Error = batch.err
+			if batch.err != nil {
+				continue
+			}
+
+			if ba, err := getAccount(am.client, r.Address); err != nil {
+				r.Error = err
+			} else {
+				r.Balance = ba.Balance
+			}
+		}
+	}
+
+	var sorted []SourceProvision
+	for _, r := range results {
+		sorted = append(sorted, *r)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Address < sorted[j].Address
+	})
+
+	return sorted
+}
+
+// printProvisionResults prints the table of results and returns false if
+// any of them failed.
+func printProvisionResults(results []SourceProvision) bool {
+	ok := true
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tSTATE\tBALANCE\tHASH\tERROR")
+	for _, r := range results {
+		state := "existed"
+		switch {
+		case r.Error != nil:
+			state = "failed"
+			ok = false
+		case !r.Existed:
+			state = "created"
+		}
+
+		var errString string
+		if r.Error != nil {
+			errString = r.Error.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Address, state, r.Balance, r.Hash, errString)
+	}
+	w.Flush()
+
+	return ok
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"boscoin.io/sebak/lib/common"
+	"boscoin.io/sebak/lib/transaction/operation"
+)
+
+var (
+	sweepCmd *cobra.Command
+
+	flagSweepTo      string = common.GetENVValue("SEBAK_SWEEP_TO", "")
+	flagSweepDryRun  bool   = common.GetENVValue("SEBAK_SWEEP_DRY_RUN", "0") == "1"
+	flagSweepTimeout string = common.GetENVValue("SEBAK_SWEEP_TIMEOUT", "60s")
+)
+
+func init() {
+	sweepCmd = &cobra.Command{
+		Use:   "sweep",
+		Short: "send the balance of sources back to master account",
+		Args:  cobra.ExactArgs(0),
+		Run: func(c *cobra.Command, args []string) {
+			if len(flagConfig) > 0 {
+				if err := loadConfig(c, flagConfig); err != nil {
+					printFlagsError(c, "--config", err)
+				}
+			}
+
+			timeout, err := time.ParseDuration(flagSweepTimeout)
+			if err != nil {
+				printFlagsError(c, "--timeout", err)
+			}
+
+			parseFlagsClient(c)
+			loadSources(c)
+
+			to := kp.Address()
+			if len(flagSweepTo) > 0 {
+				if err := checkAddress(flagSweepTo); err != nil {
+					printFlagsError(c, "--to", err)
+				}
+				to = flagSweepTo
+			}
+			if _, found := sources[to]; found {
+				printFlagsError(c, "--to", fmt.Errorf("'%s' is one of sources", to))
+			}
+
+			am := NewAccountManager([]byte(flagNetworkID), kp, sebakEndpoint, sources, nil)
+			results := am.Sweep(to, flagSweepDryRun, timeout)
+
+			if !printSweepResults(results, flagSweepDryRun) {
+				os.Exit(1)
+			}
+		},
+	}
+
+	sweepCmd.Flags().StringVar(&flagConfig, "config", flagConfig, "config file of run command, YAML or TOML")
+	addClientFlags(sweepCmd)
+	sweepCmd.Flags().StringVar(&flagSources, "sources", flagSources, "source account list file")
+	sweepCmd.Flags().StringVar(&flagSweepTo, "to", flagSweepTo, "destination address; default is master account")
+	sweepCmd.Flags().BoolVar(&flagSweepDryRun, "dry-run", flagSweepDryRun, "print the amounts without sending transactions")
+	sweepCmd.Flags().StringVar(&flagSweepTimeout, "timeout", flagSweepTimeout, "maximum time to wait the confirmation of each transaction")
+
+	rootCmd.AddCommand(sweepCmd)
+}
+
+// SweepResult is the result of sweeping one source account.
+type SweepResult struct {
+	Address string
+	Balance common.Amount
+	Amount  common.Amount
+	Hash    string
+	Error   error
+}
+
+// Sweep sends the balance of each source except the fee to the address and
+// waits until the transactions are confirmed. With dryRun, the transactions
+// are not sent.
+func (am *AccountManager) Sweep(to string, dryRun bool, timeout time.Duration) []SweepResult {
+	var results []SweepResult
+	var l sync.Mutex
+	var wg sync.WaitGroup
+
+	workers := make(chan struct{}, 10)
+	for _, account := range am.accounts {
+		wg.Add(1)
+		workers <- struct{}{}
+
+		go func(account *Account) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+
+			r := am.sweepSource(account, to, dryRun, timeout)
+			if r.Error != nil {
+				log.Error("failed to sweep", "source", r.Address, "error", r.Error)
+			}
+
+			l.Lock()
+			results = append(results, r)
+			l.Unlock()
+		}(account)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Address < results[j].Address
+	})
+
+	return results
+}
+
+func (am *AccountManager) sweepSource(source *Account, to string, dryRun bool, timeout time.Duration) (r SweepResult) {
+	r.Address = source.KP.Address()
+
+	ba, err := getAccount(am.client, r.Address)
+	if err != nil {
+		r.Error = err
+		return
+	}
+	r.Balance = ba.Balance
+
+	if r.Balance <= common.BaseFee {
+		return
+	}
+	r.Amount = r.Balance - common.BaseFee
+
+	if dryRun {
+		return
+	}
+
+	var sequenceID uint64
+	if sequenceID, err = am.getSequenceID(r.Address); err != nil {
+		r.Error = err
+		return
+	}
+
+	ra := ReadyAccount{
+		Type:    operation.TypePayment,
+		Address: to,
+		Balance: r.Amount,
+	}
+	tx, err := newBatchTransaction(am.networkID, source.KP, sequenceID, timeout, ra)
+	if err != nil {
+		r.Error = err
+		return
+	}
+	r.Hash = tx.GetHash()
+
+	if r.Error = am.sendTransaction(tx); r.Error != nil {
+		return
+	}
+	r.Error = am.confirmTransaction(r.Hash, r.Address, sequenceID, timeout)
+
+	return
+}
+
+// printSweepResults prints the table of results and returns false if any of
+// them failed.
+func printSweepResults(results []SweepResult, dryRun bool) bool {
+	ok := true
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tSTATE\tBALANCE\tAMOUNT\tHASH\tERROR")
+	for _, r := range results {
+		var errString string
+		var state string
+		switch {
+		case r.Error != nil:
+			state = "failed"
+			errString = r.Error.Error()
+			ok = false
+		case r.Amount < 1:
+			state = "empty"
+		case dryRun:
+			state = "dry-run"
+		default:
+			state = "swept"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Address, state, r.Balance, r.Amount, r.Hash, errString)
+	}
+	w.Flush()
+
+	return ok
+}
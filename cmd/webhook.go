@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"boscoin.io/sebak/lib/common"
+	"boscoin.io/sebak/lib/transaction/operation"
+)
+
+const (
+	webhookSignatureHeader string = "X-Angelbot-Signature"
+	webhookTimestampHeader string = "X-Angelbot-Timestamp"
+
+	// maxWebhookBackoff is the maximum wait between the retries.
+	maxWebhookBackoff time.Duration = time.Minute
+	// maxWebhookDeliveries is the maximum number of webhooks being posted at
+	// once.
+	maxWebhookDeliveries int = 20
+)
+
+// webhookDeniedNetworks is the internal networks, which the webhook can not
+// be delivered to, besides the loopback, link-local and unspecified
+// addresses.
+var webhookDeniedNetworks []*net.IPNet
+
+func init() {
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		webhookDeniedNetworks = append(webhookDeniedNetworks, n)
+	}
+}
+
+// WebhookOptions configures the callbacks of finished requests.
+type WebhookOptions struct {
+	// AllowHosts is the hosts of callback url; `*.example.com` allows the
+	// subdomains.
+	AllowHosts []string
+	// Secret signs the payload by HMAC-SHA256.
+	Secret string
+	// Retries is the maximum number of retries after the first delivery.
+	Retries int
+	// Backoff is the wait before the first retry; it is doubled for every
+	// retry up to maxWebhookBackoff.
+	Backoff time.Duration
+	Timeout time.Duration
+}
+
+func (o WebhookOptions) Enabled() bool {
+	return len(o.AllowHosts) > 0
+}
+
+// WebhookPayload is the body of callback.
+type WebhookPayload struct {
+	ID      string                  `json:"id"`
+	Type    operation.OperationType `json:"type"`
+	Address string                  `json:"address"`
+	Balance common.Amount           `json:"balance"`
+	Status  RequestState            `json:"status"`
+	Hash    string                  `json:"hash,omitempty"`
+	Error   string                  `json:"error,omitempty"`
+	Created time.Time               `json:"created"`
+	Updated time.Time               `json:"updated"`
+}
+
+// Webhooks posts the signed WebhookPayload to the callback url of the
+// request, when it is confirmed or failed.
+type Webhooks struct {
+	options    WebhookOptions
+	client     *http.Client
+	deliveries chan struct{}
+}
+
+func NewWebhooks(options WebhookOptions) *Webhooks {
+	// the resolved address of callback url is checked at dialing, so the
+	// allowed host can not point to the internal network.
+	dialer := &net.Dialer{
+		Timeout: options.Timeout,
+		Control: checkWebhookAddress,
+	}
+
+	return &Webhooks{
+		options: options,
+		client: &http.Client{
+			Timeout: options.Timeout,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: options.Timeout,
+			},
+			// the redirection is not followed; it may lead to the host,
+			// which is not allowed.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		deliveries: make(chan struct{}, maxWebhookDeliveries),
+	}
+}
+
+// checkWebhookAddress rejects the connection to the loopback, private,
+// link-local and unspecified addresses.
+func checkWebhookAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("webhook: invalid address, '%s'", address)
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("webhook: address is not allowed, '%s'", address)
+	}
+	for _, n := range webhookDeniedNetworks {
+		if n.Contains(ip) {
+			return fmt.Errorf("webhook: address is not allowed, '%s'", address)
+		}
+	}
+
+	return nil
+}
+
+// CheckURL checks the callback url is http or https and it's host is
+// allowed; the ip address is not allowed as host.
+func (wh *Webhooks) CheckURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("callback_url: unknown scheme, '%s'", u.Scheme)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if net.ParseIP(host) != nil {
+		return fmt.Errorf("callback_url: ip address is not allowed, '%s'", host)
+	}
+
+	for _, allowed := range wh.options.AllowHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed {
+			return nil
+		}
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("callback_url: host is not allowed, '%s'", host)
+}
+
+// sign returns the signature of the body, `hex(hmac-sha256(<timestamp>.<body>))`.
+func (wh *Webhooks) sign(timestamp string, body []byte) string {
+	return hex.EncodeToString(webhookMAC(wh.options.Secret, timestamp, body))
+}
+
+func webhookMAC(secret, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return mac.Sum(nil)
+}
+
+var (
+	errWebhookSignature = errors.New("invalid webhook signature")
+	errWebhookTimestamp = errors.New("webhook timestamp is too old or in future")
+)
+
+// VerifyWebhookSignature checks the webhook, which the callback receives,
+// is signed with secret; the timestamp must be within tolerance from now, so
+// the delivered webhook can not be replayed later. 0 tolerance does not check
+// the timestamp.
+func VerifyWebhookSignature(secret string, header http.Header, body []byte, tolerance time.Duration) error {
+	timestamp := header.Get(webhookTimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errWebhookTimestamp
+	}
+	if tolerance > 0 {
+		if d := time.Since(time.Unix(unix, 0)); d > tolerance || d < -tolerance {
+			return errWebhookTimestamp
+		}
+	}
+
+	signature := header.Get(webhookSignatureHeader)
+	if !strings.HasPrefix(signature, "sha256=") {
+		return errWebhookSignature
+	}
+	decoded, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return errWebhookSignature
+	}
+	if !hmac.Equal(decoded, webhookMAC(secret, timestamp, body)) {
+		return errWebhookSignature
+	}
+
+	return nil
+}
+
+// Notify delivers the finished request to the callback urls of it and of
+// the attached requests in background.
+func (wh *Webhooks) Notify(entry RequestEntry) {
+	if !entry.State.Finished() {
+		return
+	}
+
+	var callbackURLs []string
+	if len(entry.CallbackURL) > 0 {
+		callbackURLs = append(callbackURLs, entry.CallbackURL)
+	}
+	callbackURLs = append(callbackURLs, entry.AttachedCallbackURLs...)
+	if len(callbackURLs) < 1 {
+		return
+	}
+
+	body, err := json.Marshal(WebhookPayload{
+		ID:      entry.ID,
+		Type:    entry.Type,
+		Address: entry.Address,
+		Balance: entry.Balance,
+		Status:  entry.State,
+		Hash:    entry.Hash,
+		Error:   entry.Error,
+		Created: entry.Created,
+		Updated: entry.Updated,
+	})
+	if err != nil {
+		log.Error("failed to make webhook payload", "id", entry.ID, "error", err)
+		return
+	}
+
+	for _, callbackURL := range callbackURLs {
+		go wh.deliver(entry.ID, callbackURL, body)
+	}
+}
+
+func (wh *Webhooks) deliver(id, callbackURL string, body []byte) {
+	backoff := wh.options.Backoff
+	for i := 0; i <= wh.options.Retries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxWebhookBackoff {
+				backoff = maxWebhookBackoff
+			}
+		}
+
+		wh.deliveries <- struct{}{}
+		err := wh.post(callbackURL, body)
+		<-wh.deliveries
+
+		if err == nil {
+			log.Debug("webhook delivered", "id", id, "url", callbackURL)
+			return
+		}
+		log.Error("failed to deliver webhook", "id", id, "url", callbackURL, "try", i+1, "error", err)
+	}
+
+	log.Error("gave up webhook", "id", id, "url", callbackURL)
+}
+
+func (wh *Webhooks) post(callbackURL string, body []byte) error {
+	req, err := http.NewRequest("POST", callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+wh.sign(timestamp, body))
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status, %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWebhooksCheckURL(t *testing.T) {
+	wh := NewWebhooks(WebhookOptions{
+		AllowHosts: []string{"hooks.example.com", "*.example.org"},
+		Timeout:    time.Second,
+	})
+
+	cases := []struct {
+		url string
+		err bool
+	}{
+		{url: "https://hooks.example.com/callback"},
+		{url: "http://HOOKS.example.com:8080/callback"},
+		{url: "https://a.example.org/callback"},
+		{url: "https://a.b.example.org/callback"},
+		{url: "https://example.org/callback", err: true},
+		{url: "https://badexample.org/callback", err: true},
+		{url: "https://other.example.com/callback", err: true},
+		{url: "https://hooks.example.com.evil.com/callback", err: true},
+		{url: "ftp://hooks.example.com/callback", err: true},
+		{url: "https://127.0.0.1/callback", err: true},
+		{url: "https://[::1]/callback", err: true},
+		{url: "://hooks.example.com", err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.url, func(t *testing.T) {
+			err := wh.CheckURL(c.url)
+			if c.err && err == nil {
+				t.Error("expected error")
+			} else if !c.err && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestCheckWebhookAddress(t *testing.T) {
+	cases := []struct {
+		address string
+		err     bool
+	}{
+		{address: "93.184.216.34:443"},
+		{address: "[2606:2800:220:1:248:1893:25c8:1946]:443"},
+		{address: "127.0.0.1:80", err: true},
+		{address: "[::1]:80", err: true},
+		{address: "0.0.0.0:80", err: true},
+		{address: "10.1.2.3:80", err: true},
+		{address: "172.16.0.1:80", err: true},
+		{address: "192.168.1.1:80", err: true},
+		{address: "100.64.0.1:80", err: true},
+		{address: "169.254.169.254:80", err: true},
+		{address: "[fd00::1]:80", err: true},
+		{address: "[fe80::1]:80", err: true},
+		{address: "example.com:80", err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.address, func(t *testing.T) {
+			err := checkWebhookAddress("tcp", c.address, nil)
+			if c.err && err == nil {
+				t.Error("expected error")
+			} else if !c.err && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestWebhooksSign(t *testing.T) {
+	// the known signatures of HMAC-SHA256 with the secret, `secret`.
+	cases := []struct {
+		timestamp string
+		body      string
+		expected  string
+	}{
+		{timestamp: "1546300800", body: `{"id":"a"}`, expected: "819335e6279b970f311c7c232cef443cb02b610ed4faee3d279fcfee222fab99"},
+		{timestamp: "1546300800", body: "", expected: "b89003e64711223300fde595c6f4865277498be008b9fe04b4cc62079eb4fbce"},
+	}
+
+	wh := NewWebhooks(WebhookOptions{Secret: "secret"})
+	for _, c := range cases {
+		if signature := wh.sign(c.timestamp, []byte(c.body)); signature != c.expected {
+			t.Errorf("timestamp=%s body=%s: expected %s; got %s", c.timestamp, c.body, c.expected, signature)
+		}
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"id":"a"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	cases := []struct {
+		name      string
+		timestamp string
+		signature string
+		body      []byte
+		tolerance time.Duration
+		err       bool
+	}{
+		{name: "known signature", timestamp: "1546300800", signature: "sha256=819335e6279b970f311c7c232cef443cb02b610ed4faee3d279fcfee222fab99", body: body},
+		{name: "signed now", timestamp: now, body: body, tolerance: time.Minute},
+		{name: "too old", timestamp: old, body: body, tolerance: time.Minute, err: true},
+		{name: "old without tolerance", timestamp: old, body: body},
+		{name: "other body", timestamp: now, body: []byte(`{"id":"b"}`), err: true},
+		{name: "other timestamp", timestamp: "1546300801", signature: "sha256=819335e6279b970f311c7c232cef443cb02b610ed4faee3d279fcfee222fab99", body: body, err: true},
+		{name: "without prefix", timestamp: "1546300800", signature: "819335e6279b970f311c7c232cef443cb02b610ed4faee3d279fcfee222fab99", body: body, err: true},
+		{name: "not hex", timestamp: "1546300800", signature: "sha256=zz", body: body, err: true},
+		{name: "empty signature", timestamp: "1546300800", signature: "sha256=", body: body, err: true},
+		{name: "invalid timestamp", timestamp: "2019-01-01", body: body, err: true},
+	}
+
+	wh := NewWebhooks(WebhookOptions{Secret: "secret"})
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			signature := c.signature
+			if len(signature) < 1 {
+				// signed by webhook with the original body.
+				signature = "sha256=" + wh.sign(c.timestamp, body)
+			}
+
+			header := http.Header{}
+			header.Set("X-Angelbot-Timestamp", c.timestamp)
+			header.Set("X-Angelbot-Signature", signature)
+
+			err := VerifyWebhookSignature("secret", header, c.body, c.tolerance)
+			if c.err && err == nil {
+				t.Error("expected error")
+			} else if !c.err && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}